@@ -0,0 +1,8 @@
+#!/bin/bash
+# Runs a job without isolating it, skipping the runner arguments: child --mem MEM [--tty] JOB_ID EXECUTABLE [ARGS...]
+shift 3
+if [ "$1" == "--tty" ]; then
+  shift
+fi
+shift
+exec "$@"
@@ -23,20 +23,26 @@ func main() {
 	switch command {
 	case "child":
 		if len(args) < 2 {
-			log.Fatalf("Usage: child [--cpu N] [--io N] [--mem N] JOB_ID EXECUTABLE [ARGS]\n")
+			log.Fatalf("Usage: child [--cpu N] [--io N] [--mem N] [--tty] JOB_ID EXECUTABLE [ARGS]\n")
 		}
 
 		// TODO: use a better arg/option parsing lib
 		// TODO: handle cmd line options and limits for CPU/IO
 		fs := flag.NewFlagSet("child", flag.ContinueOnError)
 		mem := fs.Int("mem", 0, "Max memory usage in MB")
+		tty := fs.Bool("tty", false, "Run in a pseudo-terminal")
 		err := fs.Parse(args)
 		if err != nil {
 			log.Fatalf("Cannot parse arguments: %s\n", err)
 		}
 		remaining := fs.Args()
 
-		runChild(s, os.Args[0], *mem, remaining...)
+		runChild(s, &scheduler.JobSpec{
+			Executable: os.Args[0],
+			Args:       remaining,
+			Memory:     *mem,
+			Tty:        *tty,
+		})
 	default:
 		log.Fatalf(usage)
 	}
@@ -44,9 +50,9 @@ func main() {
 	log.Reset()
 }
 
-func runChild(s *scheduler.Scheduler, executable string, mem int, params ...string) {
-	log.Infof("Starting scheduler with \"%s\"\n", helpers.FormatCmdLine(executable, params...))
-	_, err := s.Start(executable, mem, params...)
+func runChild(s *scheduler.Scheduler, spec *scheduler.JobSpec) {
+	log.Infof("Starting scheduler with \"%s\"\n", helpers.FormatCmdLine(spec.Executable, spec.Args...))
+	_, err := s.StartJob(spec)
 	if err != nil {
 		log.Fatalf("Error: %s\n", err)
 		return
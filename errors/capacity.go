@@ -0,0 +1,11 @@
+package errors
+
+import "fmt"
+
+type CapacityError struct {
+	Resource string
+}
+
+func (e *CapacityError) Error() string {
+	return fmt.Sprintf("not enough %s capacity", e.Resource)
+}
@@ -5,4 +5,5 @@ go 1.16
 require (
 	github.com/fatih/color v1.13.0
 	github.com/google/uuid v1.3.0
+	golang.org/x/sys v0.0.0-20210630005230-0f9fa26af87c
 )
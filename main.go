@@ -34,19 +34,24 @@ func main() {
 			log.Fatalf("Usage: run [--cpu N] [--io N] [--mem N] EXECUTABLE [ARGS]\n")
 		}
 
-		mem, remaining := parseArgs(args)
+		mem, _, remaining := parseArgs(args)
 
 		executable := remaining[0]
 		args = remaining[1:]
 		runParent(s, executable, mem, args...)
 	case "child":
 		if len(args) < 2 {
-			log.Fatalf("Usage: child [--cpu N] [--io N] [--mem N] JOB_ID EXECUTABLE [ARGS]\n")
+			log.Fatalf("Usage: child [--cpu N] [--io N] [--mem N] [--tty] JOB_ID EXECUTABLE [ARGS]\n")
 		}
 
-		mem, remaining := parseArgs(args)
+		mem, tty, remaining := parseArgs(args)
 
-		runChild(s, os.Args[0], mem, remaining...)
+		runChild(s, &scheduler.JobSpec{
+			Executable: os.Args[0],
+			Args:       remaining,
+			Memory:     mem,
+			Tty:        tty,
+		})
 	default:
 		log.Fatalf(usage)
 	}
@@ -54,18 +59,19 @@ func main() {
 	log.Reset()
 }
 
-func parseArgs(args []string) (int, []string) {
+func parseArgs(args []string) (int, bool, []string) {
 	// TODO: use a better arg/option parsing lib
 	// TODO: handle cmd line options and limits for CPU/IO
 	fs := flag.NewFlagSet("child", flag.ContinueOnError)
 	mem := fs.Int("mem", 0, "Max memory usage in MB")
+	tty := fs.Bool("tty", false, "Run in a pseudo-terminal")
 	err := fs.Parse(args)
 	if err != nil {
 		log.Fatalf("Cannot parseArgs arguments: %s\n", err)
 	}
 	remaining := fs.Args()
 
-	return *mem, remaining
+	return *mem, *tty, remaining
 }
 
 func runParent(s *scheduler.Scheduler, executable string, mem int, params ...string) {
@@ -87,9 +93,9 @@ func runParent(s *scheduler.Scheduler, executable string, mem int, params ...str
 	log.Infoln("Schedule completed")
 }
 
-func runChild(s *scheduler.Scheduler, executable string, mem int, params ...string) {
-	log.Infof("Starting scheduler with \"%s\"\n", helpers.FormatCmdLine(executable, params...))
-	_, err := s.Start(executable, mem, params...)
+func runChild(s *scheduler.Scheduler, spec *scheduler.JobSpec) {
+	log.Infof("Starting scheduler with \"%s\"\n", helpers.FormatCmdLine(spec.Executable, spec.Args...))
+	_, err := s.StartJob(spec)
 	if err != nil {
 		log.Fatalf("Error: %v", err)
 	}
@@ -0,0 +1,118 @@
+package scheduler
+
+import (
+	"fmt"
+	"github.com/beoboo/job-scheduler/library/errors"
+)
+
+// Resources holds an amount of CPU (in millicores), memory (in bytes) and PIDs
+type Resources struct {
+	CPU    int
+	Memory int
+	Pids   int
+}
+
+// Admission sets what happens to the jobs that don't fit in the free capacity of the scheduler
+type Admission int
+
+const (
+	// AdmissionQueue queues the jobs until there's enough free capacity
+	AdmissionQueue Admission = 0
+	// AdmissionReject fails to start the jobs
+	AdmissionReject Admission = 1
+)
+
+// Capacity reports the resources of the scheduler, allocated to the running jobs or free
+// (the free amount is 0 for the resources without a limit)
+type Capacity struct {
+	Allocatable Resources
+	Allocated   Resources
+	Free        Resources
+}
+
+// WithCapacity sets the resources that can be allocated to the running jobs (0 means no limit for a resource).
+// A job is only started if its requests fit in the free capacity, or it's handled as set by admission.
+func WithCapacity(allocatable Resources, admission Admission) Option {
+	return func(s *Scheduler) {
+		s.allocatable = allocatable
+		s.admission = admission
+	}
+}
+
+// Capacity returns the resources allocated to the running jobs, and the free ones.
+func (s *Scheduler) Capacity() Capacity {
+	s.m.RLock("Capacity")
+	defer s.m.RUnlock("Capacity")
+
+	return Capacity{
+		Allocatable: s.allocatable,
+		Allocated:   s.allocated,
+		Free: Resources{
+			CPU:    free(s.allocatable.CPU, s.allocated.CPU),
+			Memory: free(s.allocatable.Memory, s.allocated.Memory),
+			Pids:   free(s.allocatable.Pids, s.allocated.Pids),
+		},
+	}
+}
+
+// admit checks if a job can ever fit in the capacity of the scheduler, and if it fits now when queueing is not
+// allowed (the lock has to be held)
+func (s *Scheduler) admit(j *job) error {
+	req := j.spec.requests()
+
+	if err := fits(req, s.allocatable, Resources{}); err != nil {
+		return err
+	}
+
+	if s.admission == AdmissionReject {
+		return fits(req, s.allocatable, s.allocated)
+	}
+
+	return nil
+}
+
+// fits checks if the requests of a job fit in the capacity, given the resources already allocated
+func fits(req, allocatable, allocated Resources) error {
+	if allocatable.CPU > 0 && allocated.CPU+req.CPU > allocatable.CPU {
+		return &errors.CapacityError{Resource: "CPU"}
+	}
+	if allocatable.Memory > 0 && allocated.Memory+req.Memory > allocatable.Memory {
+		return &errors.CapacityError{Resource: "memory"}
+	}
+	if allocatable.Pids > 0 && allocated.Pids+req.Pids > allocatable.Pids {
+		return &errors.CapacityError{Resource: "PIDs"}
+	}
+
+	return nil
+}
+
+func free(allocatable, allocated int) int {
+	if allocatable == 0 {
+		return 0
+	}
+
+	return allocatable - allocated
+}
+
+// requests returns the resources requested by the job. Without an explicit request, the memory limit is used.
+func (s *JobSpec) requests() Resources {
+	req := s.Requests
+	if req.Memory == 0 {
+		req.Memory = s.Memory
+	}
+
+	return req
+}
+
+// validateRequests checks that the requests are not negative, and within the limits
+func (s *JobSpec) validateRequests() error {
+	if s.Requests.CPU < 0 || s.Requests.Memory < 0 || s.Requests.Pids < 0 {
+		return fmt.Errorf("invalid negative resource requests")
+	}
+
+	if s.Memory > 0 && s.Requests.Memory > s.Memory {
+		return fmt.Errorf("memory request %d exceeds the limit %d", s.Requests.Memory, s.Memory)
+	}
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package scheduler
+
+import (
+	"github.com/beoboo/job-scheduler/library/errors"
+	"io/ioutil"
+	"testing"
+)
+
+func TestSchedulerCapacityQueue(t *testing.T) {
+	s := New(QueueRunner, WithCapacity(Resources{Memory: 100}, AdmissionQueue))
+
+	id1, _ := s.StartJob(&JobSpec{Executable: "sleep", Args: []string{"0.1"}, Requests: Resources{Memory: 60}})
+	id2, _ := s.StartJob(&JobSpec{Executable: "sleep", Args: []string{"0"}, Requests: Resources{Memory: 60}})
+
+	assertSchedulerStatus(t, s, id1, Running, -1)
+	assertSchedulerStatus(t, s, id2, Queued, -1)
+	assertCapacity(t, s, Resources{Memory: 60}, Resources{Memory: 40})
+
+	s.Wait()
+
+	assertSchedulerStatus(t, s, id2, Exited, 0)
+	assertCapacity(t, s, Resources{}, Resources{Memory: 100})
+}
+
+func TestSchedulerCapacityReject(t *testing.T) {
+	s := New(QueueRunner, WithCapacity(Resources{CPU: 1000, Pids: 10}, AdmissionReject))
+
+	_, err := s.StartJob(&JobSpec{Executable: "sleep", Args: []string{"0.1"}, Requests: Resources{CPU: 500, Pids: 10}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.StartJob(&JobSpec{Executable: "sleep", Args: []string{"0"}, Requests: Resources{CPU: 500, Pids: 1}})
+	if _, ok := err.(*errors.CapacityError); !ok {
+		t.Fatalf("Job exceeding the free capacity should be rejected, got %v", err)
+	}
+
+	s.Wait()
+}
+
+func TestSchedulerCapacityRejectWithOutputDir(t *testing.T) {
+	dir := t.TempDir()
+	s := New(QueueRunner, WithCapacity(Resources{Memory: 100}, AdmissionReject), WithOutputDir(dir, 0, 0))
+
+	_, err := s.StartJob(&JobSpec{Executable: "sleep", Args: []string{"0"}, Requests: Resources{Memory: 200}})
+	if _, ok := err.(*errors.CapacityError); !ok {
+		t.Fatalf("Job exceeding the capacity should be rejected, got %v", err)
+	}
+
+	// A rejected job leaves no log file behind
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 0 {
+		t.Fatalf("Expected no log files, got %d", len(files))
+	}
+}
+
+func TestSchedulerCapacityExceeded(t *testing.T) {
+	s := New(QueueRunner, WithCapacity(Resources{Memory: 100}, AdmissionQueue))
+
+	// The memory limit is requested, when there's no explicit request
+	_, err := s.StartJob(&JobSpec{Executable: "sleep", Args: []string{"0"}, Memory: 200})
+	if _, ok := err.(*errors.CapacityError); !ok {
+		t.Fatalf("Job exceeding the capacity should be rejected, got %v", err)
+	}
+}
+
+func TestSchedulerInvalidRequests(t *testing.T) {
+	for _, spec := range []*JobSpec{
+		{Executable: "sleep", Requests: Resources{CPU: -1}},
+		{Executable: "sleep", Memory: 100, Requests: Resources{Memory: 200}},
+	} {
+		if err := spec.validate(); err == nil {
+			t.Fatalf("Spec with requests %+v and memory limit %d should be invalid", spec.Requests, spec.Memory)
+		}
+	}
+}
+
+func assertCapacity(t *testing.T, s *Scheduler, expectedAllocated, expectedFree Resources) {
+	c := s.Capacity()
+
+	if c.Allocated != expectedAllocated {
+		t.Fatalf("Expected allocated resources %+v, got %+v", expectedAllocated, c.Allocated)
+	}
+
+	if c.Free != expectedFree {
+		t.Fatalf("Expected free resources %+v, got %+v", expectedFree, c.Free)
+	}
+}
@@ -0,0 +1,184 @@
+package scheduler
+
+import (
+	"bufio"
+	"github.com/beoboo/job-scheduler/library/log"
+	"golang.org/x/sys/unix"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cgroupRoot is where the cgroup controllers are mounted (it's a variable so that tests can replace it).
+// Only cgroup v1 is supported, with a hierarchy for every controller.
+var cgroupRoot = "/sys/fs/cgroup"
+
+// statsControllers are the cgroup controllers used to collect the resource usage of a job
+var statsControllers = []string{"memory", "cpuacct", "blkio", "pids"}
+
+// userHz is the unit of the times reported by cpuacct.stat (USER_HZ, which is 100 on all supported platforms)
+const userHz = 100
+
+// cgroupDir returns the cgroup folder of a job for a controller
+func cgroupDir(controller, jobId string) string {
+	return filepath.Join(cgroupRoot, controller, jobId)
+}
+
+// memoryCgroup returns the memory cgroup folder of a job
+func memoryCgroup(jobId string) string {
+	return cgroupDir("memory", jobId)
+}
+
+// hasController checks if a cgroup controller is mounted, and new cgroups can be created in it
+func hasController(controller string) bool {
+	return unix.Access(filepath.Join(cgroupRoot, controller), unix.W_OK) == nil &&
+		unix.Access(filepath.Join(cgroupRoot, controller, "cgroup.procs"), unix.W_OK) == nil
+}
+
+// removeCgroups removes the cgroup folders of a job (they can only be removed once no process is left in them)
+func removeCgroups(jobId string) {
+	for _, controller := range statsControllers {
+		dir := cgroupDir(controller, jobId)
+		if err := os.Remove(dir); err != nil && !os.IsNotExist(err) {
+			log.Debugf("Cannot remove cgroup \"%s\": %v\n", dir, err)
+		}
+	}
+}
+
+// readCgroupStats fills the stats with the usage accounted in the cgroups of a job.
+// It returns false if the job has no cgroups.
+func readCgroupStats(jobId string, st *JobStats) bool {
+	found := false
+
+	if values, err := readKeyValues(filepath.Join(cgroupDir("cpuacct", jobId), "cpuacct.stat")); err == nil {
+		found = true
+		st.CpuUser = ticksToDuration(values["user"])
+		st.CpuSystem = ticksToDuration(values["system"])
+	}
+
+	dir := memoryCgroup(jobId)
+	if current, err := readInt(filepath.Join(dir, "memory.usage_in_bytes")); err == nil {
+		found = true
+		st.MemoryCurrent = current
+	}
+
+	if peak, err := readPeakMemory(dir); err == nil && peak > 0 {
+		st.MemoryPeak = peak
+	}
+
+	if read, written, err := readIoServiceBytes(filepath.Join(cgroupDir("blkio", jobId), "blkio.throttle.io_service_bytes")); err == nil {
+		found = true
+		st.IoReadBytes = read
+		st.IoWriteBytes = written
+	}
+
+	if pids, err := readInt(filepath.Join(cgroupDir("pids", jobId), "pids.current")); err == nil {
+		found = true
+		st.Pids = int(pids)
+	}
+
+	return found
+}
+
+// readIoServiceBytes sums the bytes read and written on all devices, from lines like "8:0 Read 4096"
+func readIoServiceBytes(path string) (int64, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	var read, written int64
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 {
+			continue
+		}
+
+		value, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		switch fields[1] {
+		case "Read":
+			read += value
+		case "Write":
+			written += value
+		}
+	}
+
+	return read, written, scanner.Err()
+}
+
+func ticksToDuration(ticks int64) time.Duration {
+	return time.Duration(ticks) * time.Second / userHz
+}
+
+// readOOMKills returns how many processes in the memory cgroup have been killed by the OOM killer (from
+// memory.oom_control)
+func readOOMKills(dir string) (int, error) {
+	values, err := readKeyValues(filepath.Join(dir, "memory.oom_control"))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return int(values["oom_kill"]), nil
+}
+
+// readPeakMemory returns the maximum memory usage (in bytes) recorded for the memory cgroup (from
+// memory.max_usage_in_bytes)
+func readPeakMemory(dir string) (int64, error) {
+	value, err := readInt(filepath.Join(dir, "memory.max_usage_in_bytes"))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+
+	return value, err
+}
+
+// readInt reads a file containing a single integer value
+func readInt(path string) (int64, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+}
+
+// readKeyValues reads a file made of "KEY VALUE" lines, like memory.oom_control or memory.stat
+func readKeyValues(path string) (map[string]int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values := make(map[string]int64)
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+
+		value, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		values[fields[0]] = value
+	}
+
+	return values, scanner.Err()
+}
@@ -0,0 +1,127 @@
+package scheduler
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReadOOMKills(t *testing.T) {
+	dir := t.TempDir()
+	writeCgroupFile(t, dir, "memory.oom_control", "oom_kill_disable 0\nunder_oom 0\noom_kill 2\n")
+
+	assertOOMKills(t, dir, 2)
+}
+
+func TestReadOOMKillsWithoutCgroup(t *testing.T) {
+	assertOOMKills(t, filepath.Join(t.TempDir(), "unknown"), 0)
+}
+
+func TestReadPeakMemory(t *testing.T) {
+	dir := t.TempDir()
+	writeCgroupFile(t, dir, "memory.max_usage_in_bytes", "4096\n")
+
+	peak, err := readPeakMemory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if peak != 4096 {
+		t.Fatalf("Peak memory should be %d, got %d", 4096, peak)
+	}
+}
+
+func TestReadCgroupStats(t *testing.T) {
+	root := cgroupRoot
+	cgroupRoot = t.TempDir()
+	defer func() { cgroupRoot = root }()
+
+	writeCgroupFile(t, cgroupDir("cpuacct", "job"), "cpuacct.stat", "user 150\nsystem 20\n")
+	writeCgroupFile(t, cgroupDir("memory", "job"), "memory.usage_in_bytes", "2048\n")
+	writeCgroupFile(t, cgroupDir("memory", "job"), "memory.max_usage_in_bytes", "4096\n")
+	writeCgroupFile(t, cgroupDir("blkio", "job"), "blkio.throttle.io_service_bytes", "8:0 Read 100\n8:0 Write 10\n8:16 Read 50\n8:0 Total 160\nTotal 160\n")
+	writeCgroupFile(t, cgroupDir("pids", "job"), "pids.current", "3\n")
+
+	st := &JobStats{}
+	if !readCgroupStats("job", st) {
+		t.Fatalf("Cgroup stats should be found")
+	}
+
+	expected := JobStats{
+		CpuUser:       1500 * time.Millisecond,
+		CpuSystem:     200 * time.Millisecond,
+		MemoryCurrent: 2048,
+		MemoryPeak:    4096,
+		IoReadBytes:   150,
+		IoWriteBytes:  10,
+		Pids:          3,
+	}
+	if *st != expected {
+		t.Fatalf("Cgroup stats should be %+v, got %+v", expected, *st)
+	}
+}
+
+func TestReadCgroupStatsWithoutCgroups(t *testing.T) {
+	root := cgroupRoot
+	cgroupRoot = t.TempDir()
+	defer func() { cgroupRoot = root }()
+
+	if readCgroupStats("job", &JobStats{}) {
+		t.Fatalf("Cgroup stats should not be found")
+	}
+}
+
+func TestCgroupsWithoutAccounting(t *testing.T) {
+	root := cgroupRoot
+	cgroupRoot = t.TempDir()
+	defer func() { cgroupRoot = root }()
+
+	// The cgroup of the job can't be created (there's a file in its place), so its usage is not accounted
+	writeCgroupFile(t, filepath.Join(cgroupRoot, "pids"), "cgroup.procs", "")
+	writeCgroupFile(t, filepath.Join(cgroupRoot, "pids"), "job", "")
+
+	j := newJob(nil)
+	if err := j.cgroups("job", 0); err != nil {
+		t.Fatalf("Job should run without accounting, got %v", err)
+	}
+}
+
+func TestRemoveCgroups(t *testing.T) {
+	root := cgroupRoot
+	cgroupRoot = t.TempDir()
+	defer func() { cgroupRoot = root }()
+
+	for _, controller := range []string{"memory", "pids"} {
+		if err := os.MkdirAll(cgroupDir(controller, "job"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	removeCgroups("job")
+
+	for _, controller := range statsControllers {
+		if _, err := os.Stat(cgroupDir(controller, "job")); !os.IsNotExist(err) {
+			t.Fatalf("Cgroup \"%s\" should be removed", controller)
+		}
+	}
+}
+
+func assertOOMKills(t *testing.T, dir string, expected int) {
+	kills, err := readOOMKills(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kills != expected {
+		t.Fatalf("OOM kills should be %d, got %d", expected, kills)
+	}
+}
+
+func writeCgroupFile(t *testing.T, dir, name, content string) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,82 @@
+package scheduler
+
+import (
+	"sync"
+	"time"
+)
+
+// fakeClock is a clock whose time only changes when it's advanced, firing the timers due in the meantime
+type fakeClock struct {
+	now    time.Time
+	timers []*fakeTimer
+	m      sync.Mutex
+}
+
+type fakeTimer struct {
+	at      time.Time
+	f       func()
+	stopped bool
+	c       *fakeClock
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return c.now
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) timer {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	t := &fakeTimer{at: c.now.Add(d), f: f, c: c}
+	c.timers = append(c.timers, t)
+
+	return t
+}
+
+// Advance moves the time forward by d, firing the due timers in order
+func (c *fakeClock) Advance(d time.Duration) {
+	c.m.Lock()
+	target := c.now.Add(d)
+
+	for {
+		var next *fakeTimer
+		for _, t := range c.timers {
+			if !t.stopped && !t.at.After(target) && (next == nil || t.at.Before(next.at)) {
+				next = t
+			}
+		}
+
+		if next == nil {
+			break
+		}
+
+		if next.at.After(c.now) {
+			c.now = next.at
+		}
+		next.stopped = true
+
+		c.m.Unlock()
+		next.f()
+		c.m.Lock()
+	}
+
+	c.now = target
+	c.m.Unlock()
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.c.m.Lock()
+	defer t.c.m.Unlock()
+
+	active := !t.stopped
+	t.stopped = true
+
+	return active
+}
@@ -0,0 +1,185 @@
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronExpr is a parsed cron expression, holding a bit for every allowed value of each field
+type cronExpr struct {
+	seconds uint64
+	minutes uint64
+	hours   uint64
+	days    uint64
+	months  uint64
+	weekday uint64
+	// When both the day of the month and of the week are restricted, either of them has to match (like in cron)
+	anyDay     bool
+	anyWeekday bool
+}
+
+type cronField struct {
+	name     string
+	min, max int
+}
+
+var cronFields = []cronField{
+	{"second", 0, 59},
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// parseCron parses a cron expression: "SECOND MINUTE HOUR DAY_OF_MONTH MONTH DAY_OF_WEEK".
+// Every field can be "*" (or "?"), a value, a range "A-B", a step "*/N" or "A-B/N", or a list of them separated by
+// commas. The seconds can be omitted (meaning 0), and both 0 and 7 are Sunday.
+func parseCron(expr string) (*cronExpr, error) {
+	fields := strings.Fields(expr)
+	if len(fields) == 5 {
+		fields = append([]string{"0"}, fields...)
+	}
+	if len(fields) != 6 {
+		return nil, fmt.Errorf("invalid cron expression \"%s\", expected 6 fields", expr)
+	}
+
+	bits := make([]uint64, len(fields))
+	for i, field := range fields {
+		b, err := parseCronField(field, cronFields[i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression \"%s\": %v", expr, err)
+		}
+		bits[i] = b
+	}
+
+	// Sunday can be 7 too
+	if bits[5]&(1<<7) != 0 {
+		bits[5] |= 1
+	}
+
+	return &cronExpr{
+		seconds:    bits[0],
+		minutes:    bits[1],
+		hours:      bits[2],
+		days:       bits[3],
+		months:     bits[4],
+		weekday:    bits[5],
+		anyDay:     isWildcard(fields[3]),
+		anyWeekday: isWildcard(fields[5]),
+	}, nil
+}
+
+func parseCronField(field string, f cronField) (uint64, error) {
+	var bits uint64
+
+	for _, part := range strings.Split(field, ",") {
+		rng, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step \"%s\" for %s", part[i+1:], f.name)
+			}
+			rng, step = part[:i], n
+		}
+
+		from, to := f.min, f.max
+		if !isWildcard(rng) {
+			bounds := strings.SplitN(rng, "-", 2)
+
+			var err error
+			if from, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("invalid value \"%s\" for %s", bounds[0], f.name)
+			}
+
+			to = from
+			if len(bounds) == 2 {
+				if to, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("invalid value \"%s\" for %s", bounds[1], f.name)
+				}
+			} else if step > 1 {
+				// "A/N" means from A to the max
+				to = f.max
+			}
+		}
+
+		if from < f.min || to > f.max || from > to {
+			return 0, fmt.Errorf("invalid range \"%s\" for %s (%d-%d)", rng, f.name, f.min, f.max)
+		}
+
+		for v := from; v <= to; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+
+	return bits, nil
+}
+
+func isWildcard(field string) bool {
+	return field == "*" || field == "?"
+}
+
+// next returns the first time after t matching the expression, in the location of t.
+// Times skipped by a daylight saving time change never match.
+// It returns the zero time if there's none in the next 5 years (i.e. for February 30th).
+func (c *cronExpr) next(t time.Time) time.Time {
+	loc := t.Location()
+	limit := t.AddDate(5, 0, 0)
+
+	// The expression has a one second resolution
+	t = t.Add(time.Second - time.Duration(t.Nanosecond()))
+
+	for t.Before(limit) {
+		y, mo, d := t.Date()
+		h, mi, _ := t.Clock()
+
+		// Minutes and seconds are the same in every time zone, while the other fields follow the calendar
+		switch {
+		case !has(c.months, int(mo)):
+			t = after(t, time.Date(y, mo+1, 1, 0, 0, 0, 0, loc))
+		case !c.matchesDay(t):
+			t = after(t, time.Date(y, mo, d+1, 0, 0, 0, 0, loc))
+		case !has(c.hours, h):
+			t = after(t, time.Date(y, mo, d, h+1, 0, 0, 0, loc))
+		case !has(c.minutes, mi):
+			t = t.Truncate(time.Minute).Add(time.Minute)
+		case !has(c.seconds, t.Second()):
+			t = t.Add(time.Second)
+		default:
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+func (c *cronExpr) matchesDay(t time.Time) bool {
+	day := has(c.days, t.Day())
+	weekday := has(c.weekday, int(t.Weekday()))
+
+	switch {
+	case c.anyDay && c.anyWeekday:
+		return true
+	case c.anyDay:
+		return weekday
+	case c.anyWeekday:
+		return day
+	default:
+		return day || weekday
+	}
+}
+
+func has(bits uint64, v int) bool {
+	return bits&(1<<uint(v)) != 0
+}
+
+// after returns next, unless a daylight saving time change makes it not later than t (then the next hour is used)
+func after(t, next time.Time) time.Time {
+	if !next.After(t) {
+		return t.Truncate(time.Hour).Add(time.Hour)
+	}
+
+	return next
+}
@@ -0,0 +1,86 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		expr     string
+		expected time.Time
+	}{
+		{"* * * * * *", start.Add(time.Second)},
+		{"*/15 * * * * *", start.Add(15 * time.Second)},
+		{"30 5 * * * *", time.Date(2021, 1, 1, 0, 5, 30, 0, time.UTC)},
+		{"0 0 12 * * *", time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)},
+		{"0 0 0 1 3 *", time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)},
+		// 2021-01-01 was a Friday
+		{"0 0 0 * * 1", time.Date(2021, 1, 4, 0, 0, 0, 0, time.UTC)},
+		{"0 0 0 * * 7", time.Date(2021, 1, 3, 0, 0, 0, 0, time.UTC)},
+		// Either the day of the month or of the week
+		{"0 0 0 15 * 1", time.Date(2021, 1, 4, 0, 0, 0, 0, time.UTC)},
+		{"0 0 10-12/2 * * *", time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC)},
+		{"0 0,30 * * * *", time.Date(2021, 1, 1, 0, 30, 0, 0, time.UTC)},
+		// Without seconds
+		{"15 * * * *", time.Date(2021, 1, 1, 0, 15, 0, 0, time.UTC)},
+		{"0 0 0 30 2 *", time.Time{}},
+	}
+
+	for _, test := range tests {
+		expr, err := parseCron(test.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		next := expr.next(start)
+		if !next.Equal(test.expected) {
+			t.Fatalf("Expected next time for \"%s\" to be %s, got %s", test.expr, test.expected, next)
+		}
+	}
+}
+
+func TestCronNextInTimeZone(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	expr, _ := parseCron("0 0 9 * * *")
+
+	next := expr.next(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC).In(loc))
+	expected := time.Date(2021, 1, 1, 14, 0, 0, 0, time.UTC)
+	if !next.Equal(expected) {
+		t.Fatalf("Expected next time to be %s, got %s", expected, next.UTC())
+	}
+
+	// The day of the switch to daylight saving time, 2:30 doesn't exist
+	expr, _ = parseCron("0 30 2 * * *")
+
+	next = expr.next(time.Date(2021, 3, 14, 0, 0, 0, 0, loc))
+	expected = time.Date(2021, 3, 15, 2, 30, 0, 0, loc)
+	if !next.Equal(expected) {
+		t.Fatalf("Expected next time to be %s, got %s", expected, next)
+	}
+}
+
+func TestCronInvalid(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"60 * * * * *",
+		"* * 24 * * *",
+		"* * * 0 * *",
+		"* * * * 13 *",
+		"* * * * * 8",
+		"*/0 * * * * *",
+		"5-1 * * * * *",
+		"a * * * * *",
+	} {
+		if _, err := parseCron(expr); err == nil {
+			t.Fatalf("Cron expression \"%s\" should be invalid", expr)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package scheduler
+
+import (
+	"github.com/beoboo/job-scheduler/library/log"
+	"time"
+)
+
+// delay holds a job in the Scheduled status until at, when it's submitted like any other job (the lock has to be
+// held).
+// Delayed jobs are waited for by Wait, like the running ones.
+func (s *Scheduler) delay(j *job, at time.Time) {
+	j.updateScheduled(at)
+	s.wg.Add(j.id, 1)
+
+	s.delayed[j.id] = s.clock.AfterFunc(at.Sub(s.clock.Now()), func() {
+		s.startDelayed(j)
+	})
+}
+
+// startDelayed submits a delayed job, unless it's been cancelled in the meantime
+func (s *Scheduler) startDelayed(j *job) {
+	defer s.wg.Done(j.id)
+
+	s.m.WLock("startDelayed")
+	if _, ok := s.delayed[j.id]; !ok {
+		s.m.WUnlock("startDelayed")
+		return
+	}
+	delete(s.delayed, j.id)
+	// The job is not scheduled anymore, so that it can't be cancelled while starting
+	j.updateStatus(Idle)
+	s.m.WUnlock("startDelayed")
+
+	if err := s.submit(j); err != nil {
+		log.Debugf("Cannot start delayed job %s: %v\n", j.id, err)
+		j.fail(err)
+	}
+}
+
+func (j *job) updateScheduled(at time.Time) {
+	j.m.WLock("updateScheduled")
+	defer j.m.WUnlock("updateScheduled")
+
+	j.sts.Type = Scheduled
+	j.sts.StartAt = at
+}
@@ -0,0 +1,75 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSchedulerStartAfter(t *testing.T) {
+	s, c := newScheduledScheduler()
+
+	id, err := s.StartJob(&JobSpec{Executable: "sleep", Args: []string{"0"}, StartAfter: 10 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st, _ := s.Status(id)
+	assertStatus(t, st, Scheduled, -1)
+	if !st.StartAt.Equal(scheduleStart.Add(10 * time.Second)) {
+		t.Fatalf("Expected job to start at %s, got %s", scheduleStart.Add(10*time.Second), st.StartAt)
+	}
+
+	c.Advance(9 * time.Second)
+	assertSchedulerStatus(t, s, id, Scheduled, -1)
+
+	c.Advance(time.Second)
+	s.Wait()
+
+	assertSchedulerStatus(t, s, id, Exited, 0)
+}
+
+func TestSchedulerStartAt(t *testing.T) {
+	s, c := newScheduledScheduler()
+
+	id, _ := s.StartJob(&JobSpec{Executable: "sleep", Args: []string{"0"}, StartAt: scheduleStart.Add(time.Minute)})
+	assertSchedulerStatus(t, s, id, Scheduled, -1)
+
+	c.Advance(time.Minute)
+	s.Wait()
+
+	assertSchedulerStatus(t, s, id, Exited, 0)
+
+	// A time in the past starts the job immediately
+	id, _ = s.StartJob(&JobSpec{Executable: "sleep", Args: []string{"0.1"}, StartAt: scheduleStart})
+	assertSchedulerStatus(t, s, id, Running, -1)
+
+	s.Wait()
+}
+
+func TestSchedulerCancelScheduled(t *testing.T) {
+	s, c := newScheduledScheduler()
+
+	id, _ := s.StartJob(&JobSpec{Executable: "sleep", Args: []string{"0"}, StartAfter: time.Second})
+
+	st, err := s.Cancel(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertStatus(t, st, Cancelled, -1)
+
+	c.Advance(time.Second)
+	s.Wait()
+
+	assertSchedulerStatus(t, s, id, Cancelled, -1)
+}
+
+func TestSchedulerInvalidStartTime(t *testing.T) {
+	for _, spec := range []*JobSpec{
+		{Executable: "sleep", StartAt: time.Now(), StartAfter: time.Second},
+		{Executable: "sleep", StartAfter: -time.Second},
+	} {
+		if err := spec.validate(); err == nil {
+			t.Fatalf("Spec starting at %s or after %s should be invalid", spec.StartAt, spec.StartAfter)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package scheduler
+
+import (
+	"bytes"
+	"github.com/beoboo/job-scheduler/library/logsync"
+	"time"
+)
+
+// Framing sets how the output of a job is split into the lines of its stream
+type Framing int
+
+const (
+	// FramingRaw stores every chunk read from the output as a line (that's suitable for binary output)
+	FramingRaw Framing = 0
+	// FramingLines stores every line of text (split on newlines) as a line
+	FramingLines Framing = 1
+)
+
+const (
+	// MAX_LINE_LENGTH is the default max length of a line, when framing by lines
+	MAX_LINE_LENGTH = 64 * 1024
+)
+
+// framer splits the output of a job into lines, passing them to emit
+type framer interface {
+	write(p []byte) error
+	// flush emits a pending partial line, if partial lines are flushed (see JobSpec.FlushTimeout)
+	flush()
+	// close emits any pending data
+	close() error
+}
+
+func newFramer(spec *JobSpec, emit func(text []byte) error) framer {
+	if spec.Framing != FramingLines {
+		return &rawFramer{emit: emit}
+	}
+
+	maxLen := spec.MaxLineLength
+	if maxLen <= 0 {
+		maxLen = MAX_LINE_LENGTH
+	}
+
+	return &lineFramer{
+		emit:    emit,
+		maxLen:  maxLen,
+		timeout: spec.FlushTimeout,
+		m:       logsync.NewMutex("lineFramer"),
+	}
+}
+
+// rawFramer emits every chunk as it is
+type rawFramer struct {
+	emit func(text []byte) error
+}
+
+func (f *rawFramer) write(p []byte) error {
+	return f.emit(p)
+}
+
+func (f *rawFramer) flush() {
+}
+
+func (f *rawFramer) close() error {
+	return nil
+}
+
+// lineFramer emits a line for every newline found, or when a line is longer than maxLen.
+// If timeout is set, a partial line without a trailing newline is emitted once it's been pending for that long.
+type lineFramer struct {
+	emit    func(text []byte) error
+	maxLen  int
+	timeout time.Duration
+	buf     []byte
+	timer   *time.Timer
+	m       logsync.Mutex
+}
+
+func (f *lineFramer) write(p []byte) error {
+	f.m.WLock("write")
+	defer f.m.WUnlock("write")
+
+	f.buf = append(f.buf, p...)
+
+	for {
+		n := bytes.IndexByte(f.buf, '\n') + 1
+		if n == 0 || n > f.maxLen {
+			if len(f.buf) < f.maxLen {
+				break
+			}
+			n = f.maxLen
+		}
+
+		if err := f.emitLine(n); err != nil {
+			return err
+		}
+	}
+
+	if len(f.buf) == 0 {
+		f.stopTimer()
+	} else if f.timeout > 0 && f.timer == nil {
+		f.timer = time.AfterFunc(f.timeout, f.flush)
+	}
+
+	return nil
+}
+
+func (f *lineFramer) close() error {
+	f.m.WLock("close")
+	defer f.m.WUnlock("close")
+
+	f.stopTimer()
+
+	if len(f.buf) == 0 {
+		return nil
+	}
+
+	return f.emitLine(len(f.buf))
+}
+
+// flush emits the pending partial line, after the timeout
+func (f *lineFramer) flush() {
+	f.m.WLock("flush")
+	defer f.m.WUnlock("flush")
+
+	f.stopTimer()
+
+	if f.timeout > 0 && len(f.buf) > 0 {
+		_ = f.emitLine(len(f.buf))
+	}
+}
+
+// emitLine emits the first n bytes of the buffer (as a copy, because the buffer is reused)
+func (f *lineFramer) emitLine(n int) error {
+	line := make([]byte, n)
+	copy(line, f.buf[:n])
+	f.buf = f.buf[n:]
+
+	return f.emit(line)
+}
+
+func (f *lineFramer) stopTimer() {
+	if f.timer != nil {
+		f.timer.Stop()
+		f.timer = nil
+	}
+}
@@ -0,0 +1,82 @@
+package scheduler
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRawFramer(t *testing.T) {
+	lines, f := newTestFramer(&JobSpec{})
+
+	_ = f.write([]byte("a\nb"))
+	_ = f.write([]byte("c\n"))
+	_ = f.close()
+
+	lines.assert(t, "a\nb", "c\n")
+}
+
+func TestLineFramer(t *testing.T) {
+	lines, f := newTestFramer(&JobSpec{Framing: FramingLines})
+
+	_ = f.write([]byte("a\nb"))
+	_ = f.write([]byte("c\nd\ne"))
+	_ = f.close()
+
+	lines.assert(t, "a\n", "bc\n", "d\n", "e")
+}
+
+func TestLineFramerMaxLineLength(t *testing.T) {
+	lines, f := newTestFramer(&JobSpec{Framing: FramingLines, MaxLineLength: 3})
+
+	_ = f.write([]byte("abcdefg\nhi\n"))
+	_ = f.close()
+
+	lines.assert(t, "abc", "def", "g\n", "hi\n")
+}
+
+func TestLineFramerFlushTimeout(t *testing.T) {
+	lines, f := newTestFramer(&JobSpec{Framing: FramingLines, FlushTimeout: 10 * time.Millisecond})
+
+	_ = f.write([]byte("prompt: "))
+
+	time.Sleep(50 * time.Millisecond)
+	lines.assert(t, "prompt: ")
+
+	_ = f.write([]byte("answer\n"))
+	_ = f.close()
+
+	lines.assert(t, "prompt: ", "answer\n")
+}
+
+type testLines struct {
+	lines []string
+	m     sync.Mutex
+}
+
+func newTestFramer(spec *JobSpec) (*testLines, framer) {
+	lines := &testLines{}
+
+	return lines, newFramer(spec, func(text []byte) error {
+		lines.m.Lock()
+		defer lines.m.Unlock()
+
+		lines.lines = append(lines.lines, string(text))
+		return nil
+	})
+}
+
+func (l *testLines) assert(t *testing.T, expected ...string) {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	if len(l.lines) != len(expected) {
+		t.Fatalf("Expected lines %q, got %q", expected, l.lines)
+	}
+
+	for i, e := range expected {
+		if l.lines[i] != e {
+			t.Fatalf("Expected lines %q, got %q", expected, l.lines)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package scheduler
+
+import (
+	"fmt"
+	"github.com/beoboo/job-scheduler/library/errors"
+	"github.com/beoboo/job-scheduler/library/log"
+	"github.com/beoboo/job-scheduler/library/logsync"
+)
+
+const (
+	// GroupLabel is the label linking the jobs of a group to it
+	GroupLabel = "group"
+)
+
+// GroupSpec describes a set of related jobs, started and stopped together
+type GroupSpec struct {
+	Jobs []JobSpec
+	// FailFast stops the other jobs of the group as soon as one of them fails (it's errored or killed)
+	FailFast bool
+}
+
+// GroupStatus describes the state of a group, and of its jobs (in the order of the spec).
+// The group is Running until all its jobs are over, then it's Exited if all of them have succeeded, or Errored.
+type GroupStatus struct {
+	Type   StatusType
+	Jobs   []string
+	Counts map[StatusType]int
+}
+
+// group holds the jobs started together by StartGroup
+type group struct {
+	id       string
+	jobs     []*job
+	stopping bool
+	m        logsync.Mutex
+}
+
+// StartGroup starts a group of jobs, returning its ID, or an error if any of them can't be started (then the ones
+// already started are stopped). If some jobs have been added, the group is still returned with the error.
+// Every member is a normal job, labelled with the ID of the group (see GroupLabel).
+func (s *Scheduler) StartGroup(spec *GroupSpec) (string, error) {
+	log.Debugf("Starting group of %d jobs\n", len(spec.Jobs))
+
+	if len(spec.Jobs) == 0 {
+		return "", fmt.Errorf("group has no jobs")
+	}
+
+	for i := range spec.Jobs {
+		if err := spec.Jobs[i].validate(); err != nil {
+			return "", fmt.Errorf("invalid job %d of group: %v", i, err)
+		}
+	}
+
+	id := generateRandomId()
+	g := &group{
+		id: id,
+		m:  logsync.NewMutex(fmt.Sprintf("group %s", id)),
+	}
+
+	for i, member := range spec.Jobs {
+		member.Labels = map[string]string{}
+		for key, value := range spec.Jobs[i].Labels {
+			member.Labels[key] = value
+		}
+		member.Labels[GroupLabel] = id
+
+		jobId, err := s.StartJob(&member)
+		if jobId != "" {
+			s.m.RLock("StartGroup")
+			g.jobs = append(g.jobs, s.jobs[jobId])
+			s.m.RUnlock("StartGroup")
+		}
+
+		if err != nil {
+			s.stopGroup(g)
+			err = fmt.Errorf("cannot start job %d of group: %v", i, err)
+
+			if len(g.jobs) == 0 {
+				return "", err
+			}
+
+			// The jobs already added are known, so they're kept in the group
+			s.addGroup(g)
+			return id, err
+		}
+	}
+
+	s.addGroup(g)
+
+	if spec.FailFast {
+		for _, j := range g.jobs {
+			go s.failFast(g, j)
+		}
+	}
+
+	return id, nil
+}
+
+func (s *Scheduler) addGroup(g *group) {
+	s.m.WLock("addGroup")
+	defer s.m.WUnlock("addGroup")
+
+	s.groups[g.id] = g
+}
+
+// GroupStatus returns the status of a group, or an error if it doesn't exist.
+func (s *Scheduler) GroupStatus(id string) (*GroupStatus, error) {
+	g, err := s.group(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return g.status(), nil
+}
+
+// StopGroup stops all the jobs of a group that are not over yet (cancelling the ones waiting to start), or returns
+// an error if the group doesn't exist.
+func (s *Scheduler) StopGroup(id string) (*GroupStatus, error) {
+	log.Debugf("Stopping group %s\n", id)
+
+	g, err := s.group(id)
+	if err != nil {
+		return nil, err
+	}
+
+	s.stopGroup(g)
+
+	return g.status(), nil
+}
+
+func (s *Scheduler) group(id string) (*group, error) {
+	s.m.RLock("group")
+	defer s.m.RUnlock("group")
+
+	g, ok := s.groups[id]
+	if !ok {
+		return nil, &errors.NotFoundError{Id: id}
+	}
+
+	return g, nil
+}
+
+// stopGroup stops (or cancels) the jobs of a group that are not over yet
+func (s *Scheduler) stopGroup(g *group) {
+	g.m.WLock("stopGroup")
+	g.stopping = true
+	jobs := append([]*job{}, g.jobs...)
+	g.m.WUnlock("stopGroup")
+
+	for _, j := range jobs {
+		switch j.status().Type {
+		case Running:
+			_, _ = s.Stop(j.id)
+		case Queued, Scheduled, Preempted:
+			// A preempted job is only queued once its process is over, so it's stopped until then
+			if _, err := s.Cancel(j.id); err != nil {
+				_, _ = s.Stop(j.id)
+			}
+		case Idle:
+			// The job is about to start, so it's cancelled before running
+			_ = j.abort()
+		}
+	}
+}
+
+// failFast waits for a job of a group to be over, stopping the others if it has failed
+func (s *Scheduler) failFast(g *group, j *job) {
+	<-j.done
+
+	if st := j.status().Type; st != Errored && st != Killed {
+		return
+	}
+
+	g.m.RLock("failFast")
+	stopping := g.stopping
+	g.m.RUnlock("failFast")
+
+	if !stopping {
+		log.Debugf("Job %s of group %s has failed, stopping the others\n", j.id, g.id)
+		s.stopGroup(g)
+	}
+}
+
+func (g *group) status() *GroupStatus {
+	g.m.RLock("status")
+	defer g.m.RUnlock("status")
+
+	gs := &GroupStatus{Type: Exited, Counts: make(map[StatusType]int)}
+	running, failed := false, false
+	for _, j := range g.jobs {
+		st := j.status().Type
+
+		gs.Jobs = append(gs.Jobs, j.id)
+		gs.Counts[st] += 1
+
+		running = running || !st.isFinal()
+		failed = failed || st != Exited
+	}
+
+	switch {
+	case running:
+		gs.Type = Running
+	case failed:
+		gs.Type = Errored
+	}
+
+	return gs
+}
+
+// Succeeded checks if all the jobs of the group have exited successfully
+func (gs *GroupStatus) Succeeded() bool {
+	return gs.Counts[Exited] == len(gs.Jobs)
+}
+
+// Failed checks if any job of the group has failed (it's errored or killed), even if the group is still running
+func (gs *GroupStatus) Failed() bool {
+	return gs.Counts[Errored]+gs.Counts[Killed] > 0
+}
@@ -0,0 +1,164 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSchedulerGroup(t *testing.T) {
+	s := New(QueueRunner)
+
+	id, err := s.StartGroup(&GroupSpec{
+		Jobs: []JobSpec{
+			{Executable: "true"},
+			{Executable: "true"},
+			{Executable: "sh", Args: []string{"-c", "exit 1"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.Wait()
+
+	gs := assertGroupStatus(t, s, id, Errored, map[StatusType]int{Exited: 2, Errored: 1})
+	if gs.Succeeded() || !gs.Failed() {
+		t.Fatal("Group should have failed")
+	}
+
+	// Every member is a normal job, linked to the group
+	for _, member := range gs.Jobs {
+		if s.jobs[member].spec.Labels[GroupLabel] != id {
+			t.Fatalf("Job %s should be labelled with group %s", member, id)
+		}
+	}
+
+	id, _ = s.StartGroup(&GroupSpec{
+		Jobs: []JobSpec{{Executable: "true"}, {Executable: "true"}},
+	})
+
+	s.Wait()
+
+	gs = assertGroupStatus(t, s, id, Exited, map[StatusType]int{Exited: 2})
+	if !gs.Succeeded() || gs.Failed() {
+		t.Fatal("Group should have succeeded")
+	}
+}
+
+func TestSchedulerStopGroup(t *testing.T) {
+	s := New(QueueRunner)
+
+	id, _ := s.StartGroup(&GroupSpec{
+		Jobs: []JobSpec{
+			{Executable: "sleep", Args: []string{"10"}},
+			{Executable: "sleep", Args: []string{"10"}},
+		},
+	})
+
+	assertGroupStatus(t, s, id, Running, map[StatusType]int{Running: 2})
+
+	if _, err := s.StopGroup(id); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Wait()
+
+	assertGroupStatus(t, s, id, Errored, map[StatusType]int{Killed: 2})
+}
+
+func TestSchedulerStopGroupWithPreemptedJob(t *testing.T) {
+	// SIGTERM is ignored by the job, so it's preempted until the grace period is over
+	s := New(QueueRunner, WithMaxRunning(1), WithPreemption(time.Second))
+
+	id, _ := s.StartGroup(&GroupSpec{
+		Jobs: []JobSpec{{Executable: "sleep", Args: []string{"10"}}},
+	})
+	time.Sleep(50 * time.Millisecond)
+
+	_, _ = s.StartJob(&JobSpec{Executable: "sleep", Args: []string{"0.1"}, Priority: 10})
+
+	assertGroupStatus(t, s, id, Running, map[StatusType]int{Preempted: 1})
+
+	if _, err := s.StopGroup(id); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Wait()
+
+	// The job is not queued again
+	assertGroupStatus(t, s, id, Errored, map[StatusType]int{Killed: 1})
+}
+
+func TestSchedulerGroupFailFast(t *testing.T) {
+	s := New(QueueRunner)
+
+	id, _ := s.StartGroup(&GroupSpec{
+		Jobs: []JobSpec{
+			{Executable: "sleep", Args: []string{"10"}},
+			{Executable: "sh", Args: []string{"-c", "sleep 0.1; exit 1"}},
+		},
+		FailFast: true,
+	})
+
+	s.Wait()
+
+	assertGroupStatus(t, s, id, Errored, map[StatusType]int{Killed: 1, Errored: 1})
+}
+
+func TestSchedulerGroupLaunchError(t *testing.T) {
+	s := New("../bin/unknown.sh")
+
+	id, err := s.StartGroup(&GroupSpec{Jobs: []JobSpec{{Executable: "true"}, {Executable: "true"}}})
+	if err == nil {
+		t.Fatalf("Group should not start without a runner")
+	}
+
+	// The job already added is still known, as errored
+	st, err := s.GroupStatus(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(st.Jobs) != 1 || st.Counts[Errored] != 1 {
+		t.Fatalf("Expected 1 errored job, got %+v", st)
+	}
+}
+
+func TestSchedulerGroupNotValid(t *testing.T) {
+	s := New(QueueRunner)
+
+	if _, err := s.StartGroup(&GroupSpec{}); err == nil {
+		t.Fatal("An empty group should not be valid")
+	}
+
+	if _, err := s.StartGroup(&GroupSpec{Jobs: []JobSpec{{Executable: "true"}, {}}}); err == nil {
+		t.Fatal("A group with an invalid job should not be valid")
+	}
+
+	if s.Size() != 0 {
+		t.Fatalf("No job should be started, got %d", s.Size())
+	}
+
+	if _, err := s.GroupStatus("unknown"); err == nil {
+		t.Fatal("Group should not exist")
+	}
+}
+
+func assertGroupStatus(t *testing.T, s *Scheduler, id string, expectedType StatusType, expectedCounts map[StatusType]int) *GroupStatus {
+	gs, err := s.GroupStatus(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if gs.Type != expectedType {
+		t.Fatalf("Group status should be \"%s\", got \"%s\"", expectedType, gs.Type)
+	}
+
+	for st, count := range expectedCounts {
+		if gs.Counts[st] != count {
+			t.Fatalf("Expected %d %s jobs, got %d (%v)", count, st, gs.Counts[st], gs.Counts)
+		}
+	}
+
+	return gs
+}
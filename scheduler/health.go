@@ -0,0 +1,323 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"github.com/beoboo/job-scheduler/library/log"
+	"golang.org/x/sys/unix"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const (
+	defaultHealthInterval     = 10 * time.Second
+	defaultHealthTimeout      = time.Second
+	defaultHealthyThreshold   = 1
+	defaultUnhealthyThreshold = 3
+	nsenterExecutable         = "nsenter"
+	maxHealthErrorLength      = 256
+)
+
+// Health is the result of the health checks of a running job
+type Health int
+
+const (
+	// HealthUnknown means that the job has no health check, or it hasn't reached a threshold yet
+	HealthUnknown Health = 0
+	Healthy       Health = 1
+	Unhealthy     Health = 2
+)
+
+// HealthCheck tells if a running job is working, and not only alive. Exactly one of Exec, TCP and HTTP has to be
+// provided.
+type HealthCheck struct {
+	// Exec is a command run inside the namespaces of the job, that's healthy if it exits with 0
+	Exec []string
+	// TCP is an address ("HOST:PORT") connected to from the network namespace of the job
+	TCP string
+	// HTTP is a URL got from the network namespace of the job, that's healthy if it responds with a 2xx or 3xx status
+	HTTP string
+	// Interval is the time between two checks (10s if 0), and Timeout the max duration of each of them (1s if 0)
+	Interval time.Duration
+	Timeout  time.Duration
+	// StartPeriod delays the first check, giving the job the time to start up
+	StartPeriod time.Duration
+	// HealthyThreshold and UnhealthyThreshold are the consecutive successful and failed checks changing the health of
+	// the job (1 and 3 if 0)
+	HealthyThreshold   int
+	UnhealthyThreshold int
+	// Restart kills the job when it becomes unhealthy, so that it's restarted according to its restart policy
+	Restart bool
+}
+
+func (h Health) String() string {
+	switch h {
+	case Healthy:
+		return "healthy"
+	case Unhealthy:
+		return "unhealthy"
+	default:
+		return "unknown"
+	}
+}
+
+// validate checks that the health check can be used
+func (hc *HealthCheck) validate(restart *RestartPolicy) error {
+	probes := 0
+	for _, set := range []bool{len(hc.Exec) > 0, hc.TCP != "", hc.HTTP != ""} {
+		if set {
+			probes += 1
+		}
+	}
+	if probes != 1 {
+		return fmt.Errorf("a health check needs exactly one of exec, tcp or http")
+	}
+
+	if hc.Interval < 0 || hc.Timeout < 0 || hc.StartPeriod < 0 || hc.HealthyThreshold < 0 || hc.UnhealthyThreshold < 0 {
+		return fmt.Errorf("invalid negative health check")
+	}
+
+	if hc.Restart && restart.Mode == RestartNever {
+		return fmt.Errorf("a health check restarting the job needs a restart policy")
+	}
+
+	return nil
+}
+
+// checkHealth runs the health check of a job (if any) periodically, until its current incarnation is over
+func (s *Scheduler) checkHealth(j *job) {
+	hc := j.spec.Health
+	if hc == nil {
+		return
+	}
+
+	pid := j.pid()
+	successes, failures := 0, 0
+
+	var check func()
+	check = func() {
+		st := j.status()
+		if st.Type != Running || st.Pid != pid {
+			return
+		}
+
+		err := hc.probe(pid)
+		if err == nil {
+			successes, failures = successes+1, 0
+		} else {
+			successes, failures = 0, failures+1
+			log.Debugf("Health check of job %s failed: %v\n", j.id, err)
+		}
+
+		switch {
+		case successes == orDefault(hc.HealthyThreshold, defaultHealthyThreshold):
+			j.updateHealth(Healthy, nil)
+		case failures == orDefault(hc.UnhealthyThreshold, defaultUnhealthyThreshold):
+			j.updateHealth(Unhealthy, err)
+
+			if hc.Restart {
+				log.Debugf("Restarting unhealthy job %s\n", j.id)
+				if err := j.kill(); err != nil {
+					log.Debugf("Cannot kill unhealthy job %s: %v\n", j.id, err)
+				}
+				return
+			}
+		case failures > 0:
+			j.updateHealth(st.Health, err)
+		}
+
+		s.clock.AfterFunc(hc.interval(), check)
+	}
+
+	s.clock.AfterFunc(hc.StartPeriod+hc.interval(), check)
+}
+
+func (hc *HealthCheck) interval() time.Duration {
+	if hc.Interval == 0 {
+		return defaultHealthInterval
+	}
+
+	return hc.Interval
+}
+
+func (hc *HealthCheck) timeout() time.Duration {
+	if hc.Timeout == 0 {
+		return defaultHealthTimeout
+	}
+
+	return hc.Timeout
+}
+
+// probe runs the check once against the process with the given PID, returning why it's failed
+func (hc *HealthCheck) probe(pid int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), hc.timeout())
+	defer cancel()
+
+	switch {
+	case len(hc.Exec) > 0:
+		args := append([]string{"--target", itoa(pid), "--mount", "--pid", "--net", "--"}, hc.Exec...)
+		out, err := exec.CommandContext(ctx, nsenterExecutable, args...).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("%v: %s", err, truncate(out, maxHealthErrorLength))
+		}
+		return nil
+	case hc.TCP != "":
+		conn, err := dialInNetns(ctx, pid, "tcp", hc.TCP)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	default:
+		client := &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+					return dialInNetns(ctx, pid, network, address)
+				},
+				DisableKeepAlives: true,
+			},
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, hc.HTTP, nil)
+		if err != nil {
+			return err
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		_ = resp.Body.Close()
+
+		if resp.StatusCode >= http.StatusBadRequest {
+			return fmt.Errorf("unexpected HTTP status %s", resp.Status)
+		}
+		return nil
+	}
+}
+
+// dialInNetns connects to an address from the network namespace of a process.
+// The socket is created while the current thread is in that namespace, and keeps using it afterwards. The address is
+// resolved to a single IP beforehand, since the dialer would race several of them from other threads (that are not
+// in the namespace).
+func dialInNetns(ctx context.Context, pid int, network, address string) (net.Conn, error) {
+	address, err := resolveAddress(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	runtime.LockOSThread()
+
+	current, err := os.Open(fmt.Sprintf("/proc/self/task/%d/ns/net", unix.Gettid()))
+	if err != nil {
+		runtime.UnlockOSThread()
+		return nil, err
+	}
+	defer current.Close()
+
+	target, err := os.Open(fmt.Sprintf("/proc/%d/ns/net", pid))
+	if err != nil {
+		runtime.UnlockOSThread()
+		return nil, err
+	}
+	defer target.Close()
+
+	if err := setns(target); err != nil {
+		runtime.UnlockOSThread()
+		return nil, fmt.Errorf("cannot enter network namespace of process %d: %v", pid, err)
+	}
+
+	d := net.Dialer{FallbackDelay: -1}
+	conn, dialErr := d.DialContext(ctx, network, address)
+
+	if err := setns(current); err != nil {
+		// The thread is left locked, so that it's terminated with the goroutine instead of being reused
+		log.Errorf("Cannot restore network namespace: %v\n", err)
+	} else {
+		runtime.UnlockOSThread()
+	}
+
+	return conn, dialErr
+}
+
+// resolveAddress replaces the host of an address with one of its IPs (preferring IPv4), for the given network
+func resolveAddress(ctx context.Context, network, address string) (string, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", err
+	}
+
+	if net.ParseIP(host) != nil {
+		return address, nil
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return "", err
+	}
+
+	var resolved net.IP
+	for _, addr := range addrs {
+		v4 := addr.IP.To4() != nil
+		if strings.HasSuffix(network, "4") && !v4 || strings.HasSuffix(network, "6") && v4 {
+			continue
+		}
+
+		if resolved == nil || v4 && resolved.To4() == nil {
+			resolved = addr.IP
+		}
+	}
+
+	if resolved == nil {
+		return "", fmt.Errorf("no %s address for host \"%s\"", network, host)
+	}
+
+	return net.JoinHostPort(resolved.String(), port), nil
+}
+
+func setns(ns *os.File) error {
+	return unix.Setns(int(ns.Fd()), unix.CLONE_NEWNET)
+}
+
+// kill kills a running job without stopping it, so that it can be restarted
+func (j *job) kill() error {
+	j.m.RLock("kill")
+	defer j.m.RUnlock("kill")
+
+	if j.cmd == nil || j.sts.Type != Running {
+		return fmt.Errorf("job not running")
+	}
+
+	return j.cmd.Process.Kill()
+}
+
+func (j *job) updateHealth(h Health, err error) {
+	j.m.WLock("updateHealth")
+	defer j.m.WUnlock("updateHealth")
+
+	j.sts.Health = h
+	j.sts.HealthError = ""
+	if err != nil {
+		j.sts.HealthError = err.Error()
+	}
+}
+
+func orDefault(n, def int) int {
+	if n == 0 {
+		return def
+	}
+
+	return n
+}
+
+func truncate(out []byte, max int) string {
+	if len(out) > max {
+		out = out[:max]
+	}
+
+	return string(out)
+}
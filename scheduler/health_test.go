@@ -0,0 +1,163 @@
+package scheduler
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// httpServer is a job serving HTTP on the loopback interface of its own network namespace
+var httpServer = JobSpec{
+	Executable: "sh",
+	Args:       []string{"-c", "ip link set lo up && exec /usr/bin/python3 -m http.server 18080 --bind 127.0.0.1"},
+}
+
+func TestSchedulerHealthCheckHTTP(t *testing.T) {
+	s := New(QueueRunner)
+
+	spec := httpServer
+	spec.Health = &HealthCheck{HTTP: "http://127.0.0.1:18080/", Interval: 50 * time.Millisecond}
+	id, _ := s.StartJob(&spec)
+
+	waitHealth(t, s, id, Healthy)
+
+	_, _ = s.Stop(id)
+	s.Wait()
+}
+
+func TestSchedulerHealthCheckTCP(t *testing.T) {
+	s := New(QueueRunner)
+
+	spec := httpServer
+	spec.Health = &HealthCheck{TCP: "127.0.0.1:18080", Interval: 50 * time.Millisecond}
+	id, _ := s.StartJob(&spec)
+
+	waitHealth(t, s, id, Healthy)
+
+	_, _ = s.Stop(id)
+
+	// A port open on the host is not reachable from the network namespace of the job
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	spec = httpServer
+	spec.Health = &HealthCheck{TCP: l.Addr().String(), Interval: 50 * time.Millisecond, UnhealthyThreshold: 2}
+	id, _ = s.StartJob(&spec)
+
+	st := waitHealth(t, s, id, Unhealthy)
+	if st.HealthError == "" {
+		t.Fatal("Expected the reason of the failed health check")
+	}
+
+	_, _ = s.Stop(id)
+	s.Wait()
+}
+
+func TestSchedulerHealthCheckExec(t *testing.T) {
+	s := New(QueueRunner)
+
+	dir, _ := ioutil.TempDir("", "health")
+	defer os.RemoveAll(dir)
+	healthy := filepath.Join(dir, "healthy")
+	_ = ioutil.WriteFile(healthy, nil, 0644)
+
+	id, _ := s.StartJob(&JobSpec{
+		Executable: "sleep",
+		Args:       []string{"10"},
+		Health:     &HealthCheck{Exec: []string{"test", "-f", healthy}, Interval: 50 * time.Millisecond},
+	})
+
+	waitHealth(t, s, id, Healthy)
+
+	_ = os.Remove(healthy)
+	waitHealth(t, s, id, Unhealthy)
+
+	_, _ = s.Stop(id)
+	s.Wait()
+}
+
+func TestSchedulerHealthCheckRestart(t *testing.T) {
+	s := New(QueueRunner)
+
+	id, _ := s.StartJob(&JobSpec{
+		Executable: "sleep",
+		Args:       []string{"10"},
+		Restart:    RestartPolicy{Mode: RestartOnFailure, MaxRestarts: 1},
+		Health: &HealthCheck{
+			Exec:               []string{"false"},
+			Interval:           50 * time.Millisecond,
+			UnhealthyThreshold: 2,
+			Restart:            true,
+		},
+	})
+
+	s.Wait()
+
+	st, _ := s.Status(id)
+	assertStatus(t, st, Errored, -1)
+	if st.Restarts != 1 {
+		t.Fatalf("Expected 1 restart, got %d", st.Restarts)
+	}
+	if st.Health != Unhealthy {
+		t.Fatalf("Job should be unhealthy, got %s", st.Health)
+	}
+}
+
+func TestHealthCheckValidation(t *testing.T) {
+	tests := []*JobSpec{
+		{Executable: "true", Health: &HealthCheck{}},
+		{Executable: "true", Health: &HealthCheck{TCP: "127.0.0.1:80", HTTP: "http://127.0.0.1/"}},
+		{Executable: "true", Health: &HealthCheck{TCP: "127.0.0.1:80", Interval: -time.Second}},
+		{Executable: "true", Health: &HealthCheck{TCP: "127.0.0.1:80", Restart: true}},
+	}
+
+	for _, test := range tests {
+		if err := test.validate(); err == nil {
+			t.Fatalf("Health check %+v should not be valid", test.Health)
+		}
+	}
+}
+
+func TestResolveAddress(t *testing.T) {
+	tests := []struct {
+		network  string
+		address  string
+		expected string
+	}{
+		{"tcp", "127.0.0.1:80", "127.0.0.1:80"},
+		{"tcp", "localhost:80", "127.0.0.1:80"},
+		{"tcp4", "localhost:80", "127.0.0.1:80"},
+	}
+
+	for _, test := range tests {
+		resolved, err := resolveAddress(context.Background(), test.network, test.address)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if resolved != test.expected {
+			t.Fatalf("Address \"%s\" should be resolved to \"%s\", got \"%s\"", test.address, test.expected, resolved)
+		}
+	}
+}
+
+func waitHealth(t *testing.T, s *Scheduler, id string, expected Health) *JobStatus {
+	var st *JobStatus
+	for i := 0; i < 100; i++ {
+		st, _ = s.Status(id)
+		if st.Health == expected {
+			return st
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	t.Fatalf("Job health should be \"%s\", got \"%s\" (%s)", expected, st.Health, st.HealthError)
+	return nil
+}
@@ -0,0 +1,160 @@
+package scheduler
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/beoboo/job-scheduler/library/log"
+	"github.com/beoboo/job-scheduler/library/logsync"
+	"io"
+	"os"
+)
+
+// input wraps the stdin of a job, so that writes coming from different sources don't interleave
+// (i.e. the initial input from the spec is fully written before the one streamed through the Scheduler)
+type input struct {
+	w      io.WriteCloser
+	closed bool
+	// fed is closed once the initial input has been written, and closing once the input is closed (interrupting
+	// the writes waiting for the initial input)
+	fed     chan struct{}
+	closing chan struct{}
+	// wm serializes the writes, while m only guards closed (so that Close is never blocked by a pending write)
+	wm logsync.Mutex
+	m  logsync.Mutex
+}
+
+func newInput(w io.WriteCloser) *input {
+	return &input{
+		w:       w,
+		fed:     make(chan struct{}),
+		closing: make(chan struct{}),
+		wm:      logsync.NewMutex("input writes"),
+		m:       logsync.NewMutex("input"),
+	}
+}
+
+// Write writes to the stdin of the job, once the initial input has been written, or returns io.ErrClosedPipe if
+// it has been closed.
+func (in *input) Write(p []byte) (int, error) {
+	select {
+	case <-in.fed:
+	case <-in.closing:
+		return 0, io.ErrClosedPipe
+	}
+
+	in.wm.WLock("Write")
+	defer in.wm.WUnlock("Write")
+
+	if in.isClosed() {
+		return 0, io.ErrClosedPipe
+	}
+
+	return in.w.Write(p)
+}
+
+// Close closes the stdin of the job, so that it receives an EOF (interrupting any pending write).
+func (in *input) Close() error {
+	in.m.WLock("Close")
+	if in.closed {
+		in.m.WUnlock("Close")
+		return nil
+	}
+	in.closed = true
+	close(in.closing)
+	in.m.WUnlock("Close")
+
+	return in.w.Close()
+}
+
+func (in *input) isClosed() bool {
+	in.m.RLock("isClosed")
+	defer in.m.RUnlock("isClosed")
+
+	return in.closed
+}
+
+// feed copies the whole source to the stdin of the job in the background, closing it afterwards if requested.
+// Any other write waits until the copy is completed, or the input is closed (that stops the copy too).
+func (in *input) feed(source io.Reader, closeAfter bool, done func()) {
+	go func() {
+		if source != nil && !in.isClosed() {
+			if _, err := io.Copy(in.w, source); err != nil {
+				log.Debugf("Cannot write stdin: %v\n", err)
+			}
+		}
+
+		close(in.fed)
+
+		if closeAfter {
+			_ = in.Close()
+		}
+
+		done()
+	}()
+}
+
+// stdinSource returns the reader of the initial input described by the spec, if any
+func (s *JobSpec) stdinSource() (io.Reader, error) {
+	switch {
+	case s.StdinData != nil:
+		return bytes.NewReader(s.StdinData), nil
+	case s.StdinFile != "":
+		f, err := os.Open(s.StdinFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot open stdin file: %v", err)
+		}
+		return f, nil
+	default:
+		return s.StdinReader, nil
+	}
+}
+
+// setupStdin connects the stdin of the job, as described by its spec.
+// It returns a function feeding the initial input, that has to be called once the process is started.
+func (j *job) setupStdin() (func(), error) {
+	source, err := j.spec.stdinSource()
+	if err != nil {
+		return nil, err
+	}
+
+	if source == nil && !j.spec.keepsStdinOpen() {
+		// The job reads from /dev/null
+		return func() {}, nil
+	}
+
+	w, err := j.cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	in := newInput(w)
+
+	j.m.WLock("setupStdin")
+	j.in = in
+	j.m.WUnlock("setupStdin")
+
+	return func() {
+		in.feed(source, !j.spec.keepsStdinOpen(), func() {
+			// Only the file opened for the spec is closed, a reader is owned by the caller
+			if f, ok := source.(*os.File); ok {
+				_ = f.Close()
+			}
+		})
+	}, nil
+}
+
+// input returns the stdin of the job, if it's been kept open
+func (j *job) input() (io.WriteCloser, error) {
+	if !j.spec.keepsStdinOpen() {
+		return nil, fmt.Errorf("stdin of job \"%s\" is not open", j.id)
+	}
+
+	j.m.RLock("input")
+	defer j.m.RUnlock("input")
+
+	if j.in == nil {
+		return nil, fmt.Errorf("job \"%s\" not started", j.id)
+	}
+
+	return j.in, nil
+}
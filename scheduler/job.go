@@ -11,6 +11,7 @@ import (
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"os/signal"
 	"strconv"
 	"sync"
 	"syscall"
@@ -19,28 +20,72 @@ import (
 
 const (
 	BUFFER_SIZE = 1024
+	// signalExitCode is added to the signal terminating a job, in the exit code of the runner
+	signalExitCode = 128
+	maxSignal      = 64
 )
 
 // job wraps the execution of a process, capturing its stdout and stderr streams,
 // and providing the process status
 type job struct {
 	id       string
+	spec     *JobSpec
 	cmd      *exec.Cmd
 	outputSt *stream.Stream
 	sts      *JobStatus
-	m        logsync.Mutex
-	wg       *logsync.WaitGroup
+	rusage   *syscall.Rusage
+	in       *input
+	resize   *os.File
+	// usage is the one accounted in the cgroups of the job, when its process exited
+	usage *JobStats
+	// onFinished is called once the job is over (or has failed to start)
+	onFinished func()
+	// stopped is set when the job is stopped, so that it's not retried (or restarted)
+	stopped bool
+	// preempted is set when the job is preempted, until its process is over
+	preempted bool
+	// runner is set when the process runs the job as its child (see exitCode)
+	runner bool
+	// restarts are the times the job has been restarted at, and restarting is set until the restart is marked in
+	// the output
+	restarts   []time.Time
+	restarting bool
+	// stable is the time the last stable run was over at (see RestartPolicy.backoff)
+	stable time.Time
+	// delay is how long a retried (or restarted) job waits before running again
+	delay time.Duration
+	// clock is the one of the scheduler, measuring the restart window and backoff
+	clock clock
+	// done is closed once the job reaches a final status
+	done chan struct{}
+	m    logsync.Mutex
+	wg   *logsync.WaitGroup
 }
 
 // newJob creates a new job
 func newJob(wg *logsync.WaitGroup) *job {
+	return newJobFromSpec(&JobSpec{}, wg)
+}
+
+// newJobFromSpec creates a new job described by a spec, storing its output in a stream configured with opts
+func newJobFromSpec(spec *JobSpec, wg *logsync.WaitGroup, opts ...stream.Option) *job {
 	id := generateRandomId()
 	p := &job{
 		id:       id,
-		outputSt: stream.New(),
-		sts:      &JobStatus{Type: Idle, ExitCode: -1},
+		spec:     spec,
+		outputSt: stream.New(opts...),
+		done:     make(chan struct{}),
+		clock:    realClock{},
 		m:        logsync.NewMutex(fmt.Sprintf("job %s", id)),
 		wg:       wg,
+		sts: &JobStatus{
+			Type:     Idle,
+			ExitCode: -1,
+			Attempt:  1,
+			Command:  spec.cmdLine(),
+			Limits:   Limits{Memory: spec.Memory},
+			Created:  time.Now(),
+		},
 	}
 
 	return p
@@ -55,6 +100,12 @@ func (j *job) startIsolated(executable string, mem int, args ...string) error {
 	log.Debugf("Starting isolated: %s\n", helpers.FormatCmdLine(executable, args...))
 	cmd := exec.Command(executable, args...)
 
+	j.updateCommand(helpers.FormatCmdLine(executable, args...), Limits{Memory: mem})
+
+	// The runner keeps the working directory of the scheduler (so that a relative path still finds it), and applies
+	// the one of the job to the job only, together with its environment (see setupChildSpec)
+	cmd.Env = runnerEnviron()
+
 	// TODO: for simplicity, we're not handling other namespaces (i.e. UTS) or UID/GID mappings
 	cmd.SysProcAttr = &syscall.SysProcAttr{
 		Cloneflags: syscall.CLONE_NEWNS |
@@ -62,7 +113,9 @@ func (j *job) startIsolated(executable string, mem int, args ...string) error {
 			syscall.CLONE_NEWNET,
 	}
 
+	j.m.WLock("startIsolated")
 	j.cmd = cmd
+	j.m.WUnlock("startIsolated")
 
 	errCh := make(chan error, 1)
 
@@ -73,18 +126,22 @@ func (j *job) startIsolated(executable string, mem int, args ...string) error {
 
 		err := j.run(errCh)
 		if err != nil {
-			j.updateStatus(Errored)
+			j.fail(err)
 			errCh <- err
 		}
 	}()
 
 	// Waits for the job to be started successfully
-	err := <-errCh
-
-	return err
+	return <-errCh
 }
 
 func (j *job) cleanupIsolated() {
+	j.cleanupTty()
+
+	if j.onFinished != nil {
+		j.onFinished()
+	}
+
 	j.wg.Done(j.id)
 }
 
@@ -97,22 +154,86 @@ func (j *job) startChild(jobId, executable string, mem int, args ...string) (int
 	// TODO: chroot or pivot_root
 	// TODO: cd /
 
-	// TODO: set cgroups for CPU/IO
+	// TODO: set cgroups limits for CPU/IO
 	if err := j.cgroups(jobId, mem); err != nil {
 		return -1, err
 	}
 
+	// The executable is looked up with the PATH of the runner, that's not affected by the environment of the job
 	cmd := exec.Command(executable, args...)
 
+	cs, err := readChildSpec()
+	if err != nil {
+		return -1, err
+	}
+	if cs != nil {
+		cmd.Env = cs.Env
+		cmd.Dir = cs.Dir
+	}
+
+	if j.spec.Tty {
+		err := runTty(cmd, os.Stdin, os.Stdout, os.NewFile(resizeFd, "resize"))
+		if cmd.ProcessState == nil {
+			return -1, err
+		}
+		return exitCode(cmd.ProcessState), runnerError(err)
+	}
+
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 
-	if err := cmd.Run(); err != nil {
-		return cmd.ProcessState.ExitCode(), err
+	if err := cmd.Start(); err != nil {
+		return -1, err
 	}
 
-	return cmd.ProcessState.ExitCode(), nil
+	stopForwarding := forwardSignals(cmd.Process)
+	defer stopForwarding()
+
+	err = cmd.Wait()
+
+	return exitCode(cmd.ProcessState), runnerError(err)
+}
+
+// runnerError drops the error reporting how the job is over, since that's not an error of the runner (and it's
+// reported by its exit code)
+func runnerError(err error) error {
+	if _, ok := err.(*exec.ExitError); ok {
+		return nil
+	}
+
+	return err
+}
+
+// exitCode returns the exit code of a process, or 128 + the signal that terminated it (like a shell does).
+// The runner exits with it, so that the signal is not lost: as the init process of its PID namespace, it can't be
+// terminated by the same signal.
+func exitCode(ps *os.ProcessState) int {
+	if ws, ok := ps.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return signalExitCode + int(ws.Signal())
+	}
+
+	return ps.ExitCode()
+}
+
+// forwardSignals forwards the termination signals received by the child to the process, so that it can be stopped
+// gracefully (as the init process of its PID namespace, the child would be killed together with all the processes
+// in there).
+// It returns a function to stop forwarding them.
+func forwardSignals(p *os.Process) func() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+
+	go func() {
+		for sig := range signals {
+			_ = p.Signal(sig)
+		}
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(signals)
+	}
 }
 
 func (j *job) cgroups(jobId string, mem int) error {
@@ -122,7 +243,7 @@ func (j *job) cgroups(jobId string, mem int) error {
 	*/
 	if mem > 0 {
 		log.Debugf("Setting memory limit for %s to %d\n", jobId, mem)
-		dir := "/sys/fs/cgroup/memory/" + jobId
+		dir := memoryCgroup(jobId)
 
 		if err := os.MkdirAll(dir, 0755); err != nil {
 			return fmt.Errorf("error creating directory \"%s\": %v\n", dir, err)
@@ -131,15 +252,58 @@ func (j *job) cgroups(jobId string, mem int) error {
 		if err := ioutil.WriteFile(dir+"/memory.limit_in_bytes", itob(mem), 0644); err != nil {
 			return fmt.Errorf("unable to write memory limit: %v", err)
 		}
+	}
+
+	// The job joins the accounting controllers even without limits, so that its usage can be tracked.
+	// Only the memory limit is required, the job runs anyway without accounting.
+	for _, controller := range statsControllers {
+		limited := controller == "memory" && mem > 0
+
+		if !limited && !hasController(controller) {
+			log.Debugf("Cgroup controller \"%s\" not available\n", controller)
+			continue
+		}
+
+		if err := joinCgroup(controller, jobId); err != nil {
+			if limited {
+				return err
+			}
 
-		if err := ioutil.WriteFile(dir+"/cgroup.procs", itob(os.Getpid()), 0700); err != nil {
-			return fmt.Errorf("unable to write to cgroup.procs file: %v", err)
+			log.Debugf("Cannot account usage with cgroup controller \"%s\": %v\n", controller, err)
 		}
 	}
 
 	return nil
 }
 
+// joinCgroup moves the current process to the cgroup of a job for a controller
+func joinCgroup(controller, jobId string) error {
+	dir := cgroupDir(controller, jobId)
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("error creating directory \"%s\": %v", dir, err)
+	}
+
+	if err := ioutil.WriteFile(dir+"/cgroup.procs", itob(os.Getpid()), 0700); err != nil {
+		_ = os.Remove(dir)
+		return fmt.Errorf("unable to write to cgroup.procs file: %v", err)
+	}
+
+	return nil
+}
+
+// releaseCgroups records the usage accounted in the cgroups of the job, and removes them once its process is over
+func (j *job) releaseCgroups() {
+	usage := &JobStats{}
+	if readCgroupStats(j.id, usage) {
+		j.m.WLock("releaseCgroups")
+		j.usage = usage
+		j.m.WUnlock("releaseCgroups")
+	}
+
+	removeCgroups(j.id)
+}
+
 func itob(num int) []byte {
 	return []byte(itoa(num))
 }
@@ -150,16 +314,17 @@ func itoa(num int) string {
 
 func (j *job) cleanupChild() {
 	// TODO: unmount folders
-	// TODO: cleanupChild cgroups
+	// The cgroups are removed by the parent, since the child is still in them (see releaseCgroups)
 }
 
 // stop stops a running process
 func (j *job) stop() error {
 	j.m.WLock("stop")
-	if j.cmd == nil {
+	if j.cmd == nil || j.sts.Type != Running && j.sts.Type != Preempted {
 		j.m.WUnlock("stop")
-		return fmt.Errorf("job not started")
+		return fmt.Errorf("job not running")
 	}
+	j.stopped = true
 	err := j.cmd.Process.Kill()
 	j.m.WUnlock("stop")
 
@@ -171,6 +336,42 @@ func (j *job) stop() error {
 	return nil
 }
 
+// preempt stops a running job gracefully, sending SIGTERM first and SIGKILL after grace.
+// The job is moved to the Preempted status, so that it can be started again.
+func (j *job) preempt(grace time.Duration) error {
+	j.m.WLock("preempt")
+	if j.cmd == nil || j.sts.Type != Running {
+		j.m.WUnlock("preempt")
+		return fmt.Errorf("job not running")
+	}
+	j.sts.Type = Preempted
+	j.preempted = true
+	p := j.cmd.Process
+	j.m.WUnlock("preempt")
+
+	if err := p.Signal(syscall.SIGTERM); err != nil {
+		return fmt.Errorf("cannot preempt job %d: (%s)", p.Pid, err)
+	}
+
+	// Killing a process that's already exited is harmless
+	time.AfterFunc(grace, func() {
+		_ = p.Kill()
+	})
+
+	return nil
+}
+
+// wasPreempted checks if the job has been preempted, resetting it once its process is over
+func (j *job) wasPreempted() bool {
+	j.m.WLock("wasPreempted")
+	defer j.m.WUnlock("wasPreempted")
+
+	preempted := j.preempted
+	j.preempted = false
+
+	return preempted
+}
+
 // output returns the stream of captured stdout/stderr of the running process.
 func (j *job) output() *stream.Stream {
 	j.m.RLock("output")
@@ -189,6 +390,27 @@ func (j *job) status() *JobStatus {
 }
 
 func (j *job) run(started chan error) error {
+	// A job cancelled while starting is never run
+	if j.status().Type == Cancelled {
+		return fmt.Errorf("job \"%s\" has been cancelled", j.id)
+	}
+
+	feedStdin, err := j.setupStdin()
+	if err != nil {
+		return err
+	}
+
+	releaseSpec, err := j.setupChildSpec()
+	if err != nil {
+		return err
+	}
+
+	releaseTty, err := j.setupTty()
+	if err != nil {
+		releaseSpec()
+		return err
+	}
+
 	stdout, err := j.cmd.StdoutPipe()
 	if err != nil {
 		return err
@@ -199,21 +421,39 @@ func (j *job) run(started chan error) error {
 		return err
 	}
 
+	j.markAttempt()
+
 	stdoutReader := bufio.NewReader(stdout)
 	stderrReader := bufio.NewReader(stderr)
 
+	// Only the stdout of the runner is connected to the terminal
+	stdoutType := stream.Output
+	if j.spec.Tty {
+		stdoutType = stream.Tty
+	}
+
 	var wg sync.WaitGroup
 	wg.Add(2)
-	go j.pipe(stream.Output, stdoutReader, &wg)
+	go j.pipe(stdoutType, stdoutReader, &wg)
 	go j.pipe(stream.Error, stderrReader, &wg)
 
 	err = j.cmd.Start()
+	releaseSpec()
+	releaseTty()
 	if err != nil {
 		return err
 	}
 
+	j.updateStarted()
 	j.updateStatus(Running)
 
+	// The job has been cancelled while starting its process
+	if j.status().Type == Cancelled {
+		_ = j.cmd.Process.Kill()
+	}
+
+	feedStdin()
+
 	started <- err
 
 	wg.Wait()
@@ -224,27 +464,39 @@ func (j *job) run(started chan error) error {
 	// 3. the process is killed (and we can just log this and return the status and exit code)
 	err = j.cmd.Wait()
 
-	j.updateExitCode()
+	j.updateProcessState()
+	j.updateMemoryEvents()
+	j.releaseCgroups()
 
 	if err != nil {
 		log.Debugf("Error calling wait: %v\n", err)
-		j.updateStatus(Errored)
-	} else {
-		j.updateStatus(Exited)
 	}
 
+	j.finish(err)
+
 	return nil
 }
 
 func (j *job) pipe(st stream.StreamType, pipe *bufio.Reader, wg *sync.WaitGroup) {
+	f := newFramer(j.spec, func(text []byte) error {
+		// Logged only once framed, so that secrets are already redacted
+		log.Debugf("[%s] %s\n", st, text)
+
+		return j.write(st, text)
+	})
+
+	// Secrets are masked before the output is split into lines, since they can be split between chunks
+	var w framer = f
+	if r := newRedactor(j.spec, f); r != nil {
+		w = r
+	}
+
 	for {
 		buf := make([]byte, BUFFER_SIZE)
 		n, err := pipe.Read(buf)
 
 		if n > 0 {
-			log.Debugf("[%s] %s", st, buf[:n])
-
-			wErr := j.write(st, buf[:n])
+			wErr := w.write(buf[:n])
 			if wErr != nil {
 				// The stream is already has been closed, due to the updated status of the job (that's
 				// no more running). This means that the underlying execution has already finished, and
@@ -260,6 +512,16 @@ func (j *job) pipe(st stream.StreamType, pipe *bufio.Reader, wg *sync.WaitGroup)
 		}
 	}
 
+	if w != f {
+		if err := w.close(); err != nil {
+			log.Debugf("Write error: %s\n", err)
+		}
+	}
+
+	if err := f.close(); err != nil {
+		log.Debugf("Write error: %s\n", err)
+	}
+
 	wg.Done()
 }
 
@@ -280,20 +542,237 @@ func (j *job) updateStatus(st StatusType) {
 	j.m.WLock("updateStatus")
 	defer j.m.WUnlock("updateStatus")
 
-	switch j.sts.Type {
-	case Exited, Errored, Killed:
+	switch {
+	case j.sts.Type.isFinal():
 		// Do not update the status, the previous one is the one we want to keep
-	case Running:
-		j.sts.Type = st
-		j.outputSt.Close()
+	case j.sts.Type == Preempted && st != Idle && st != Running && !st.isFinal():
+		// The job is only started again (keeping its output open), or it's over
 	default:
 		j.sts.Type = st
+		if st.isFinal() {
+			j.outputSt.Close()
+			close(j.done)
+		}
 	}
 }
 
-func (j *job) updateExitCode() {
-	j.m.WLock("updateExitCode")
-	defer j.m.WUnlock("updateExitCode")
+// updateCommand records the command line and the limits of the job, unless they were already provided
+// (i.e. when it's started through a runner, the original command is the relevant one)
+func (j *job) updateCommand(cmdLine string, limits Limits) {
+	j.m.WLock("updateCommand")
+	defer j.m.WUnlock("updateCommand")
+
+	if j.sts.Command == "" {
+		j.sts.Command = cmdLine
+		j.sts.Limits = limits
+	}
+}
+
+func (j *job) updateStarted() {
+	pid := j.pid()
+	nsPid := readNsPid(pid)
+
+	j.m.WLock("updateStarted")
+	defer j.m.WUnlock("updateStarted")
+
+	j.sts.Pid = pid
+	j.sts.NsPid = nsPid
+	j.sts.Started = time.Now()
+
+	// A preempted (or retried) job is started again
+	j.sts.ExitCode = -1
+	j.sts.Signal = 0
+	j.sts.Reason = NoReason
+	j.sts.PeakMemory = 0
+	j.sts.Finished = time.Time{}
+	j.sts.Health = HealthUnknown
+	j.sts.HealthError = ""
+}
+
+func (j *job) updateProcessState() {
+	j.m.WLock("updateProcessState")
+	defer j.m.WUnlock("updateProcessState")
+
+	ps := j.cmd.ProcessState
+	j.sts.ExitCode = ps.ExitCode()
+	j.sts.Finished = time.Now()
+
+	if ws, ok := ps.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		j.sts.Signal = ws.Signal()
+	} else if j.runner && j.sts.ExitCode > signalExitCode && j.sts.ExitCode <= signalExitCode+maxSignal {
+		// The runner reports the signal that terminated the job in its exit code (see exitCode)
+		j.sts.Signal = syscall.Signal(j.sts.ExitCode - signalExitCode)
+		j.sts.ExitCode = -1
+	}
+
+	if rusage, ok := ps.SysUsage().(*syscall.Rusage); ok {
+		j.rusage = rusage
+	}
+}
+
+// updateMemoryEvents checks the memory cgroup of the job (if any), to find out if it's been killed by the OOM killer
+func (j *job) updateMemoryEvents() {
+	dir := memoryCgroup(j.id)
+
+	oomKills, err := readOOMKills(dir)
+	if err != nil {
+		log.Debugf("Cannot read OOM events for job [%s]: %v\n", j.id, err)
+	}
+
+	peak, err := readPeakMemory(dir)
+	if err != nil {
+		log.Debugf("Cannot read peak memory for job [%s]: %v\n", j.id, err)
+	}
+
+	j.m.WLock("updateMemoryEvents")
+	defer j.m.WUnlock("updateMemoryEvents")
+
+	j.sts.PeakMemory = peak
+	if oomKills > 0 {
+		j.sts.Reason = OOMKilled
+	}
+}
+
+// cancel moves a queued (or scheduled) job to the Cancelled status, so that it's never started
+func (j *job) cancel() error {
+	j.m.WLock("cancel")
+	defer j.m.WUnlock("cancel")
+
+	if j.sts.Type != Queued && j.sts.Type != Preempted && j.sts.Type != Scheduled {
+		return fmt.Errorf("job \"%s\" is not queued", j.id)
+	}
 
-	j.sts.ExitCode = j.cmd.ProcessState.ExitCode()
+	j.sts.Type = Cancelled
+	j.sts.Finished = time.Now()
+	j.outputSt.Close()
+	close(j.done)
+
+	return nil
+}
+
+// abort cancels a job that's about to start (i.e. it's been dequeued, but it's not running yet), so that it's never
+// started
+func (j *job) abort() error {
+	j.m.WLock("abort")
+	defer j.m.WUnlock("abort")
+
+	if j.sts.Type != Idle {
+		return fmt.Errorf("job \"%s\" is not starting", j.id)
+	}
+
+	j.stopped = true
+	j.sts.Type = Cancelled
+	j.sts.Finished = time.Now()
+	j.outputSt.Close()
+	close(j.done)
+
+	return nil
+}
+
+// finish moves the job to its final status, unless it has to be retried or restarted (moving it to the Scheduled
+// status)
+func (j *job) finish(err error) {
+	st := Exited
+	if err != nil {
+		st = Errored
+	}
+
+	j.m.WLock("finish")
+	stopped := j.stopped
+	// A preempted job is queued to be started again, whether it's been killed or it's stopped gracefully within the
+	// grace period, unless it's been stopped explicitly
+	if j.sts.Type == Preempted && !stopped {
+		j.m.WUnlock("finish")
+		return
+	}
+	if j.sts.Type == Running || j.sts.Type == Preempted {
+		attempt := Attempt{
+			ExitCode: j.sts.ExitCode,
+			Signal:   j.sts.Signal,
+			Reason:   j.sts.Reason,
+			Started:  j.sts.Started,
+			Finished: j.sts.Finished,
+		}
+		if err != nil {
+			attempt.Error = err.Error()
+		}
+		j.sts.Attempts = append(j.sts.Attempts, attempt)
+		if len(j.sts.Attempts) > maxHistory {
+			j.sts.Attempts = append([]Attempt{}, j.sts.Attempts[len(j.sts.Attempts)-maxHistory:]...)
+		}
+
+		if !j.stopped && j.spec.Retry.retries(st, j.sts.ExitCode, j.sts.Attempt) {
+			log.Debugf("Retrying job %s after attempt %d\n", j.id, j.sts.Attempt)
+			j.sts.Type = Scheduled
+			j.sts.Attempt += 1
+			j.delay = j.spec.Retry.backoff(j.sts.Attempt)
+			j.m.WUnlock("finish")
+			return
+		}
+
+		now := j.clock.Now()
+		if j.spec.Restart.stable(j.sts.Finished.Sub(j.sts.Started)) {
+			j.stable = now
+		}
+		if !j.stopped && j.spec.Restart.restarts(st, j.restarts, now) {
+			log.Debugf("Restarting job %s\n", j.id)
+			j.sts.Type = Scheduled
+			j.sts.Restarts += 1
+			j.sts.Attempt = 1
+			j.delay = j.spec.Restart.backoff(j.restarts, j.stable, now)
+			j.restarts = j.spec.Restart.record(j.restarts, now)
+			j.restarting = true
+			j.m.WUnlock("finish")
+			return
+		}
+	}
+	j.m.WUnlock("finish")
+
+	switch {
+	case stopped:
+		j.updateStatus(Killed)
+	case err != nil:
+		j.fail(err)
+	default:
+		j.updateStatus(Exited)
+	}
+}
+
+// markAttempt writes a line to the output, when a new attempt (or incarnation) starts
+func (j *job) markAttempt() {
+	j.m.WLock("markAttempt")
+	attempt, restarts, restarting := j.sts.Attempt, j.sts.Restarts, j.restarting
+	j.restarting = false
+	j.m.WUnlock("markAttempt")
+
+	if restarting {
+		_ = j.write(stream.Event, []byte(fmt.Sprintf("Restart %d\n", restarts)))
+	} else if attempt > 1 {
+		_ = j.write(stream.Event, []byte(fmt.Sprintf("Attempt %d of %d\n", attempt, j.spec.Retry.MaxAttempts)))
+	}
+}
+
+// nextDelay returns how long the job waits before being retried (or restarted)
+func (j *job) nextDelay() time.Duration {
+	j.m.RLock("nextDelay")
+	defer j.m.RUnlock("nextDelay")
+
+	return j.delay
+}
+
+// fail moves the job to the Errored status, recording the reason
+func (j *job) fail(err error) {
+	j.updateStatus(Errored)
+
+	j.m.WLock("fail")
+	defer j.m.WUnlock("fail")
+
+	if j.sts.Type != Errored {
+		return
+	}
+
+	j.sts.Error = err.Error()
+	if j.sts.Finished.IsZero() {
+		j.sts.Finished = time.Now()
+	}
 }
@@ -3,6 +3,11 @@ package scheduler
 import (
 	"github.com/beoboo/job-scheduler/library/logsync"
 	"github.com/beoboo/job-scheduler/library/stream"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"syscall"
 	"testing"
 	"time"
 )
@@ -52,6 +57,252 @@ func TestJobStop(t *testing.T) {
 	assertJobStatus(t, j, Killed, -1)
 }
 
+func TestJobResetsReasonOnStart(t *testing.T) {
+	j := newJob(&wg)
+
+	// The previous attempt was killed by the OOM killer
+	j.sts.Reason = OOMKilled
+	j.sts.PeakMemory = 1024
+
+	_ = j.startIsolated("sleep", 0, "0")
+
+	wg.Wait()
+
+	st := j.status()
+	if st.Reason != NoReason || st.PeakMemory != 0 {
+		t.Fatalf("Reason and peak memory should be reset, got %s and %d", st.Reason, st.PeakMemory)
+	}
+}
+
+func TestJobAbort(t *testing.T) {
+	j := newJob(&wg)
+
+	if err := j.abort(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := j.startIsolated("sleep", 0, "1"); err == nil {
+		t.Fatalf("Aborted job should not start")
+	}
+
+	wg.Wait()
+
+	assertJobStatus(t, j, Cancelled, -1)
+}
+
+func TestJobStatusDetails(t *testing.T) {
+	j := newJob(&wg)
+
+	_ = j.startIsolated("sleep", 1000, "0.1")
+
+	st := j.status()
+	if st.Pid == 0 {
+		t.Fatalf("Job PID should be set")
+	}
+	if st.NsPid != 1 {
+		t.Fatalf("Job PID inside the namespace should be 1 (as the init process), got %d", st.NsPid)
+	}
+	if st.Command != "sleep 0.1" {
+		t.Fatalf("Job command should be \"sleep 0.1\", got \"%s\"", st.Command)
+	}
+	if st.Limits.Memory != 1000 {
+		t.Fatalf("Job memory limit should be 1000, got %d", st.Limits.Memory)
+	}
+	if st.Created.IsZero() || st.Started.Before(st.Created) {
+		t.Fatalf("Job should be started after being created")
+	}
+
+	wg.Wait()
+
+	st = j.status()
+	if st.Finished.Before(st.Started) {
+		t.Fatalf("Job should be finished after being started")
+	}
+}
+
+func TestJobStopSignal(t *testing.T) {
+	j := newJob(&wg)
+
+	_ = j.startIsolated("sleep", 0, "1")
+	_ = j.stop()
+
+	wg.Wait()
+
+	st := j.status()
+	if st.Signal != syscall.SIGKILL {
+		t.Fatalf("Job should be terminated by \"%s\", got \"%s\"", syscall.SIGKILL, st.Signal)
+	}
+}
+
+func TestUnknownExecutableError(t *testing.T) {
+	j := newJob(&wg)
+
+	_ = j.startIsolated("./unknown-executable", 0)
+
+	if j.status().Error == "" {
+		t.Fatalf("Job error should be set")
+	}
+}
+
+func TestJobOOMKilled(t *testing.T) {
+	root := cgroupRoot
+	cgroupRoot = t.TempDir()
+	defer func() { cgroupRoot = root }()
+
+	j := newJob(&wg)
+	dir := memoryCgroup(j.id)
+	writeCgroupFile(t, dir, "memory.oom_control", "oom_kill_disable 0\nunder_oom 0\noom_kill 1\n")
+	writeCgroupFile(t, dir, "memory.max_usage_in_bytes", "5000000\n")
+
+	_ = j.startIsolated("sleep", 5000000, "0")
+
+	wg.Wait()
+
+	st := j.status()
+	if st.Reason != OOMKilled {
+		t.Fatalf("Job reason should be \"%s\", got \"%s\"", OOMKilled, st.Reason)
+	}
+	if st.PeakMemory != 5000000 {
+		t.Fatalf("Job peak memory should be %d, got %d", 5000000, st.PeakMemory)
+	}
+}
+
+func TestJobStats(t *testing.T) {
+	j := newJob(&wg)
+
+	_ = j.startIsolated("sleep", 0, "0.1")
+
+	st := j.stats()
+	if st.Pids != 1 || st.MemoryCurrent == 0 {
+		t.Fatalf("Job stats should report the running process, got %+v", st)
+	}
+	if st.Rusage != nil {
+		t.Fatalf("Job stats should not have a resource usage while running")
+	}
+
+	wg.Wait()
+
+	st = j.stats()
+	if st.Rusage == nil || st.MemoryPeak == 0 {
+		t.Fatalf("Job stats should report the resource usage at exit, got %+v", st)
+	}
+}
+
+func TestJobStdinData(t *testing.T) {
+	j := newJobFromSpec(&JobSpec{StdinData: []byte("data\n")}, &wg)
+
+	assertJobStdin(t, j, "data\n")
+}
+
+func TestJobStdinFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stdin")
+	if err := ioutil.WriteFile(path, []byte("file\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	j := newJobFromSpec(&JobSpec{StdinFile: path}, &wg)
+
+	assertJobStdin(t, j, "file\n")
+}
+
+func TestJobStdinReader(t *testing.T) {
+	j := newJobFromSpec(&JobSpec{StdinReader: strings.NewReader("reader\n")}, &wg)
+
+	assertJobStdin(t, j, "reader\n")
+}
+
+func TestJobStdinReaderNotRerun(t *testing.T) {
+	for _, spec := range []*JobSpec{
+		{Executable: "cat", StdinReader: strings.NewReader("reader\n"), Retry: RetryPolicy{MaxAttempts: 2}},
+		{Executable: "cat", StdinReader: strings.NewReader("reader\n"), Restart: RestartPolicy{Mode: RestartAlways}},
+	} {
+		if err := spec.validate(); err == nil {
+			t.Fatalf("Spec with a stdin reader, retry %+v and restart %+v should be invalid", spec.Retry, spec.Restart)
+		}
+	}
+}
+
+func TestJobOpenStdin(t *testing.T) {
+	j := newJobFromSpec(&JobSpec{StdinData: []byte("first\n"), OpenStdin: true}, &wg)
+
+	err := j.startIsolated("cat", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in, err := j.input()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _ = in.Write([]byte("second\n"))
+	_ = in.Close()
+
+	wg.Wait()
+
+	assertJobStatus(t, j, Exited, 0)
+
+	if _, err := in.Write([]byte("third\n")); err == nil {
+		t.Fatalf("Stdin should not be writable after being closed")
+	}
+
+	if res := collectJobOutput(j); res != "first\nsecond\n" {
+		t.Fatalf("Job output should be \"first\\nsecond\\n\", got \"%s\"", res)
+	}
+}
+
+func TestJobOpenStdinNeverEnding(t *testing.T) {
+	r, w := io.Pipe()
+	defer w.Close()
+
+	j := newJobFromSpec(&JobSpec{StdinReader: r, OpenStdin: true}, &wg)
+
+	err := j.startIsolated("cat", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in, _ := j.input()
+
+	// A write waits for the initial input, until the stdin is closed
+	written := make(chan error)
+	go func() {
+		_, err := in.Write([]byte("never\n"))
+		written <- err
+	}()
+
+	closed := make(chan error)
+	go func() {
+		closed <- in.Close()
+	}()
+
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatalf("Stdin should be closed while the initial input is still being read")
+	}
+
+	if err := <-written; err != io.ErrClosedPipe {
+		t.Fatalf("Pending write should fail once the stdin is closed, got %v", err)
+	}
+
+	wg.Wait()
+
+	assertJobStatus(t, j, Exited, 0)
+}
+
+func TestJobStdinNotOpen(t *testing.T) {
+	j := newJob(&wg)
+
+	_ = j.startIsolated("sleep", 0, "0")
+
+	if _, err := j.input(); err == nil {
+		t.Fatalf("Stdin should not be available")
+	}
+
+	wg.Wait()
+}
+
 func TestJobOutput(t *testing.T) {
 	j := newJob(&wg)
 
@@ -77,6 +328,38 @@ func TestJobOutput(t *testing.T) {
 	assertJobOutput(t, j, expected)
 }
 
+func TestJobOutputLimit(t *testing.T) {
+	// The output is split into lines, so that they don't depend on how it's read
+	j := newJobFromSpec(&JobSpec{Framing: FramingLines}, &wg, stream.WithMaxBytes(3))
+
+	err := j.startIsolated("../bin/test.sh", 0, "2", "0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wg.Wait()
+
+	// Only the last line is kept
+	assertJobOutput(t, j, []string{"#2\n"})
+
+	if l := <-j.output().Read(); l.Skipped != 2 {
+		t.Fatalf("Expected %d skipped lines, got %d", 2, l.Skipped)
+	}
+}
+
+func TestJobLineFraming(t *testing.T) {
+	j := newJobFromSpec(&JobSpec{Framing: FramingLines}, &wg)
+
+	err := j.startIsolated("printf", 0, "a\\nb\\nc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wg.Wait()
+
+	assertJobOutput(t, j, []string{"a\n", "b\n", "c"})
+}
+
 func TestJobMultipleReaders(t *testing.T) {
 	j := newJob(&wg)
 
@@ -115,6 +398,27 @@ func TestUpdateStatus(t *testing.T) {
 	assertJobStatus(t, j, Killed, -1)
 }
 
+func assertJobStdin(t *testing.T, j *job, expected string) {
+	err := j.startIsolated("cat", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wg.Wait()
+
+	assertJobStatus(t, j, Exited, 0)
+	assertJobOutput(t, j, []string{expected})
+}
+
+func collectJobOutput(j *job) string {
+	res := ""
+	for l := range j.output().Read() {
+		res += string(l.Text)
+	}
+
+	return res
+}
+
 func assertJobStatus(t *testing.T, j *job, expectedType StatusType, expectedCode int) {
 	assertStatus(t, j.status(), expectedType, expectedCode)
 }
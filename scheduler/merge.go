@@ -0,0 +1,233 @@
+package scheduler
+
+import (
+	"context"
+	"github.com/beoboo/job-scheduler/library/errors"
+	"github.com/beoboo/job-scheduler/library/log"
+	"github.com/beoboo/job-scheduler/library/stream"
+	"sync"
+)
+
+// JobLine is a line of the output of a job, in a merged stream
+type JobLine struct {
+	JobId string
+	*stream.Line
+}
+
+// MergeOutput returns the output of several jobs merged in a single channel, or an error if any of them doesn't exist.
+// The lines already written are merged in time order, while new ones are sent as soon as they're written.
+// The channel is closed once all the outputs are closed, or when ctx is done.
+func (s *Scheduler) MergeOutput(ctx context.Context, ids ...string) (<-chan *JobLine, error) {
+	log.Debugf("Merging output for jobs %v\n", ids)
+
+	s.m.RLock("MergeOutput")
+	jobs := make([]*job, len(ids))
+	for i, id := range ids {
+		j, ok := s.jobs[id]
+		if !ok {
+			s.m.RUnlock("MergeOutput")
+			return nil, &errors.NotFoundError{Id: id}
+		}
+		jobs[i] = j
+	}
+	s.m.RUnlock("MergeOutput")
+
+	m := newMerger(ctx, jobs)
+	m.start()
+	// No other job is merged, so the channel is closed once these outputs are
+	m.stop()
+	go m.close()
+
+	return m.out, nil
+}
+
+// MergeOutputBySelector works like MergeOutput, for all the jobs whose labels match the selector.
+// Jobs started later are merged too if they match, as long as the merge is not over: the channel is closed once all
+// the merged outputs are closed (or, if no job matches yet, once the first matching one is over), or when ctx is done.
+func (s *Scheduler) MergeOutputBySelector(ctx context.Context, selector map[string]string) <-chan *JobLine {
+	log.Debugf("Merging output for jobs matching %v\n", selector)
+
+	// The lock guarantees that no job is started between listing the current ones and subscribing to the new ones
+	s.m.WLock("MergeOutputBySelector")
+	var jobs []*job
+	for _, j := range s.jobs {
+		if j.spec.matches(selector) {
+			jobs = append(jobs, j)
+		}
+	}
+	m := newMerger(ctx, jobs)
+
+	unsubscribe := s.subscribe(func(j *job) {
+		if j.spec.matches(selector) {
+			m.add(j)
+		}
+	})
+	s.m.WUnlock("MergeOutputBySelector")
+
+	m.start()
+
+	go func() {
+		m.close()
+		unsubscribe()
+	}()
+
+	return m.out
+}
+
+// merger merges the output of several jobs into a single channel.
+// The lines already written are merged in time order, then new ones are forwarded as soon as they're written.
+type merger struct {
+	ctx  context.Context
+	out  chan *JobLine
+	jobs []*job
+	// open is the number of merged outputs that are not closed yet, and the merge is over once it's 0 (or once it's
+	// stopped), closing done
+	open int
+	over bool
+	done chan struct{}
+	m    sync.Mutex
+	wg   sync.WaitGroup
+}
+
+// newMerger creates a merger of the outputs of jobs (see start)
+func newMerger(ctx context.Context, jobs []*job) *merger {
+	return &merger{
+		ctx:  ctx,
+		out:  make(chan *JobLine),
+		jobs: jobs,
+		open: len(jobs),
+		done: make(chan struct{}),
+	}
+}
+
+// source is the output of a job being merged
+type source struct {
+	id    string
+	lines <-chan *stream.Line
+	head  *stream.Line
+	// pos is where to resume reading the output, after the lines already written
+	pos int
+}
+
+// start merges the lines already written to the outputs, in time order, and then follows them
+func (m *merger) start() {
+	sources := make([]*source, len(m.jobs))
+	for i, j := range m.jobs {
+		sources[i] = &source{
+			id:    j.id,
+			lines: j.output().ReadContext(m.ctx, stream.NoFollow()),
+		}
+		sources[i].next()
+	}
+
+	m.wg.Add(len(sources))
+
+	go func() {
+		for {
+			var first *source
+			for _, src := range sources {
+				if src.head != nil && (first == nil || src.head.Time.Before(first.head.Time)) {
+					first = src
+				}
+			}
+
+			if first == nil {
+				break
+			}
+
+			if !m.send(first.id, first.head) {
+				break
+			}
+			first.next()
+		}
+
+		for i, src := range sources {
+			go m.follow(src.id, m.jobs[i].output(), src.pos)
+		}
+	}()
+}
+
+// add follows the output of a job started after the merge, unless the merge is over
+func (m *merger) add(j *job) {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	if m.over {
+		return
+	}
+
+	m.open += 1
+	m.wg.Add(1)
+	go m.follow(j.id, j.output(), 0)
+}
+
+// follow forwards the lines written to the output of a job from pos
+func (m *merger) follow(id string, o *stream.Stream, pos int) {
+	defer m.wg.Done()
+	defer m.closed()
+
+	for l := range o.ReadContext(m.ctx, stream.FromPos(pos)) {
+		if !m.send(id, l) {
+			return
+		}
+	}
+}
+
+// closed records that a merged output is closed, so that the merge is over once all of them are
+func (m *merger) closed() {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.open -= 1
+	if m.open == 0 {
+		m.finish()
+	}
+}
+
+// stop prevents any other output from being merged
+func (m *merger) stop() {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.finish()
+}
+
+// finish marks the merge as over (the lock has to be held)
+func (m *merger) finish() {
+	if !m.over {
+		m.over = true
+		close(m.done)
+	}
+}
+
+func (m *merger) send(id string, l *stream.Line) bool {
+	select {
+	case m.out <- &JobLine{JobId: id, Line: l}:
+		return true
+	case <-m.ctx.Done():
+		return false
+	}
+}
+
+// close closes the merged channel once the merge is over (or ctx is done), and all the outputs are closed
+func (m *merger) close() {
+	select {
+	case <-m.done:
+	case <-m.ctx.Done():
+		m.stop()
+	}
+
+	m.wg.Wait()
+	close(m.out)
+}
+
+func (src *source) next() {
+	l, ok := <-src.lines
+	if !ok {
+		src.head = nil
+		return
+	}
+
+	src.head = l
+	src.pos = l.Pos + 1
+}
@@ -0,0 +1,28 @@
+package scheduler
+
+import "github.com/beoboo/job-scheduler/library/stream"
+
+// Option configures a Scheduler.
+type Option func(s *Scheduler)
+
+// WithOutputLimit caps the memory used by the output of each job.
+// When the cap is exceeded, the oldest lines are dropped or, if spillDir is not empty, moved to a file in there.
+func WithOutputLimit(maxBytes int, spillDir string) Option {
+	return func(s *Scheduler) {
+		s.streamOpts = append(s.streamOpts, stream.WithMaxBytes(maxBytes))
+		if spillDir != "" {
+			s.streamOpts = append(s.streamOpts, stream.WithSpillDir(spillDir))
+		}
+	}
+}
+
+// WithOutputDir persists the output of each job to a log file in dir, so that it's available after a restart.
+// Log files are rotated once they reach maxSize bytes (0 means no rotation), keeping maxFiles rotated files
+// (0 means all of them).
+func WithOutputDir(dir string, maxSize int64, maxFiles int) Option {
+	return func(s *Scheduler) {
+		s.outputDir = dir
+		s.outputMaxSize = maxSize
+		s.outputMaxFiles = maxFiles
+	}
+}
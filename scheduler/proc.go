@@ -0,0 +1,91 @@
+package scheduler
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// readNsPid returns the PID of a process inside its innermost PID namespace, or 0 if it cannot be read
+func readNsPid(pid int) int {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "NSpid:") {
+			continue
+		}
+
+		// The list goes from the outermost to the innermost namespace
+		fields := strings.Fields(strings.TrimPrefix(line, "NSpid:"))
+		if len(fields) == 0 {
+			return 0
+		}
+
+		nsPid, err := strconv.Atoi(fields[len(fields)-1])
+		if err != nil {
+			return 0
+		}
+
+		return nsPid
+	}
+
+	return 0
+}
+
+// readProcStats fills the stats with the usage of a single process, when the job has no cgroups
+func readProcStats(pid int, st *JobStats) bool {
+	data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return false
+	}
+
+	// The command name can contain spaces, so the fields are parsed after its closing parenthesis.
+	// utime and stime are the 14th and 15th fields (the 12th and 13th after the command).
+	stat := string(data)
+	fields := strings.Fields(stat[strings.LastIndex(stat, ")")+1:])
+	if len(fields) > 12 {
+		utime, _ := strconv.ParseInt(fields[11], 10, 64)
+		stime, _ := strconv.ParseInt(fields[12], 10, 64)
+		st.CpuUser = ticksToDuration(utime)
+		st.CpuSystem = ticksToDuration(stime)
+	}
+
+	if values, err := readKeyValues(fmt.Sprintf("/proc/%d/io", pid)); err == nil {
+		st.IoReadBytes = values["read_bytes:"]
+		st.IoWriteBytes = values["write_bytes:"]
+	}
+
+	if f, err := os.Open(fmt.Sprintf("/proc/%d/status", pid)); err == nil {
+		defer f.Close()
+
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) < 2 {
+				continue
+			}
+
+			// Memory values are reported in kB
+			value, _ := strconv.ParseInt(fields[1], 10, 64)
+			switch fields[0] {
+			case "VmRSS:":
+				st.MemoryCurrent = value * 1024
+			case "VmHWM:":
+				st.MemoryPeak = value * 1024
+			}
+		}
+	}
+
+	st.Pids = 1
+
+	return true
+}
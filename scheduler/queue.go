@@ -0,0 +1,273 @@
+package scheduler
+
+import (
+	"fmt"
+	"github.com/beoboo/job-scheduler/library/errors"
+	"github.com/beoboo/job-scheduler/library/log"
+	"sort"
+	"time"
+)
+
+// WithMaxRunning limits the number of jobs running at the same time (0 means no limit).
+// The jobs exceeding the limit are queued, and started as soon as the running ones are over.
+func WithMaxRunning(n int) Option {
+	return func(s *Scheduler) {
+		s.maxRunning = n
+	}
+}
+
+// WithMaxRunningPerLabel limits the number of jobs running at the same time with the same value of a label
+// (i.e. for every tenant, with key "tenant").
+func WithMaxRunningPerLabel(key string, n int) Option {
+	return func(s *Scheduler) {
+		s.maxPerLabel[key] = n
+	}
+}
+
+// WithPreemption allows a job that can't start (because of the limits on the running ones) to preempt the running job
+// with the lowest priority, if that's lower than its own.
+// The preempted job is stopped gracefully (sending SIGTERM, and SIGKILL after grace), and queued to be started again,
+// appending its new output to the previous one.
+func WithPreemption(grace time.Duration) Option {
+	return func(s *Scheduler) {
+		s.preemption = true
+		s.preemptionGrace = grace
+	}
+}
+
+// Queued returns the IDs of the queued jobs (including the preempted ones), in the order they're going to be started.
+func (s *Scheduler) Queued() []string {
+	s.m.RLock("Queued")
+	defer s.m.RUnlock("Queued")
+
+	ids := make([]string, len(s.queue))
+	for i, j := range s.queue {
+		ids[i] = j.id
+	}
+
+	return ids
+}
+
+// Cancel removes a queued (or scheduled) job from the queue, or returns an error if the job doesn't exist or it's
+// not queued.
+func (s *Scheduler) Cancel(id string) (*JobStatus, error) {
+	log.Debugf("Cancelling job %s\n", id)
+
+	s.m.WLock("Cancel")
+	defer s.m.WUnlock("Cancel")
+
+	j, ok := s.jobs[id]
+	if !ok {
+		return nil, &errors.NotFoundError{Id: id}
+	}
+
+	if t, ok := s.delayed[j.id]; ok {
+		if err := j.cancel(); err != nil {
+			return nil, err
+		}
+
+		delete(s.delayed, j.id)
+		// If the timer has already fired, the job is released when it finds out it's been cancelled
+		if t.Stop() {
+			s.wg.Done(j.id)
+		}
+
+		return j.status(), nil
+	}
+
+	// A preempted job is only queued once it's stopped
+	if s.queuePos(j) < 0 {
+		return nil, fmt.Errorf("job \"%s\" is not queued", j.id)
+	}
+
+	if err := j.cancel(); err != nil {
+		return nil, err
+	}
+
+	s.dequeue(j)
+	s.wg.Done(j.id)
+
+	return j.status(), nil
+}
+
+// reserve takes a running slot for a job, if there's one available (the lock has to be held)
+func (s *Scheduler) reserve(j *job) bool {
+	if !s.hasSlot(j) {
+		return false
+	}
+
+	s.take(j, 1)
+	s.runningJobs[j.id] = j
+	return true
+}
+
+// hasSlot checks if a job can run within the limits and the free capacity (the lock has to be held)
+func (s *Scheduler) hasSlot(j *job) bool {
+	if s.maxRunning > 0 && s.running >= s.maxRunning {
+		return false
+	}
+
+	for key, max := range s.maxPerLabel {
+		if value, ok := j.spec.Labels[key]; ok && max > 0 && s.runningByLabel[labelSlot(key, value)] >= max {
+			return false
+		}
+	}
+
+	return fits(j.spec.requests(), s.allocatable, s.allocated) == nil
+}
+
+// take updates the slots and the resources used by a job, taking (delta = 1) or freeing them (delta = -1)
+func (s *Scheduler) take(j *job, delta int) {
+	req := j.spec.requests()
+	s.allocated.CPU += delta * req.CPU
+	s.allocated.Memory += delta * req.Memory
+	s.allocated.Pids += delta * req.Pids
+
+	s.running += delta
+	for key := range s.maxPerLabel {
+		if value, ok := j.spec.Labels[key]; ok {
+			s.runningByLabel[labelSlot(key, value)] += delta
+		}
+	}
+}
+
+// preemptFor preempts the running job with the lowest priority, if stopping it leaves room for j.
+// Every job being preempted leaves room for one of the queued jobs, so nothing is preempted if that's enough
+// already (the lock has to be held).
+func (s *Scheduler) preemptFor(j *job) {
+	if !s.preemption {
+		return
+	}
+
+	waiting := 0
+	for _, queued := range s.queue {
+		if queued.spec.Priority >= j.spec.Priority && queued.status().Type == Queued {
+			waiting++
+		}
+	}
+	if waiting <= s.preempting {
+		return
+	}
+
+	var victim *job
+	for _, running := range s.runningJobs {
+		st := running.status()
+		if st.Type != Running || running.spec.Priority >= j.spec.Priority {
+			continue
+		}
+		if victim != nil && (running.spec.Priority > victim.spec.Priority ||
+			running.spec.Priority == victim.spec.Priority && st.Started.Before(victim.status().Started)) {
+			// The most recent job with the lowest priority is the one losing less work
+			continue
+		}
+
+		// The victim has to leave room for the job (i.e. it needs to have the same label when that's the limit)
+		s.take(running, -1)
+		fits := s.hasSlot(j)
+		s.take(running, 1)
+
+		if fits {
+			victim = running
+		}
+	}
+
+	if victim == nil {
+		return
+	}
+
+	log.Debugf("Preempting job %s for job %s\n", victim.id, j.id)
+	if err := victim.preempt(s.preemptionGrace); err != nil {
+		log.Debugf("Cannot preempt job %s: %v\n", victim.id, err)
+		return
+	}
+
+	s.preempting += 1
+}
+
+// release frees the running slot of a job that's over, starting the queued jobs that can take it
+func (s *Scheduler) release(j *job) {
+	s.m.WLock("release")
+	defer s.m.WUnlock("release")
+
+	s.take(j, -1)
+	delete(s.runningJobs, j.id)
+
+	// A preempted job leaves room for a queued one, even if it's over instead of being queued again
+	if j.wasPreempted() {
+		s.preempting -= 1
+	}
+
+	switch j.status().Type {
+	case Preempted:
+		s.enqueue(j)
+	case Scheduled:
+		// The job is retried (or restarted) after the backoff
+		s.delay(j, s.clock.Now().Add(j.nextDelay()))
+	}
+
+	// The queue is scanned in order, but a job blocked by the limit of its label doesn't block the others
+	for _, next := range append([]*job{}, s.queue...) {
+		if !s.reserve(next) {
+			continue
+		}
+
+		s.dequeue(next)
+		// The job is not queued anymore, so that it can't be cancelled while starting
+		next.updateStatus(Idle)
+		go s.launchQueued(next)
+	}
+}
+
+// enqueue adds a job to the queue, after the ones with a higher or equal priority (the lock has to be held).
+// A preempted job goes before the ones with its same priority instead, since it was already running.
+// Queued jobs are waited for by Wait, like the running ones.
+func (s *Scheduler) enqueue(j *job) {
+	preempted := j.status().Type == Preempted
+	if !preempted {
+		j.updateStatus(Queued)
+	}
+
+	pos := sort.Search(len(s.queue), func(i int) bool {
+		if preempted {
+			return s.queue[i].spec.Priority <= j.spec.Priority
+		}
+		return s.queue[i].spec.Priority < j.spec.Priority
+	})
+
+	s.queue = append(s.queue, nil)
+	copy(s.queue[pos+1:], s.queue[pos:])
+	s.queue[pos] = j
+
+	s.wg.Add(j.id, 1)
+}
+
+// dequeue removes a job from the queue (the lock has to be held)
+func (s *Scheduler) dequeue(j *job) {
+	if i := s.queuePos(j); i >= 0 {
+		s.queue = append(s.queue[:i], s.queue[i+1:]...)
+	}
+}
+
+// queuePos returns the position of a job in the queue, or -1 if it's not queued (the lock has to be held)
+func (s *Scheduler) queuePos(j *job) int {
+	for i, queued := range s.queue {
+		if queued == j {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// launchQueued starts a job that's been waiting in the queue
+func (s *Scheduler) launchQueued(j *job) {
+	defer s.wg.Done(j.id)
+
+	if err := s.launch(j); err != nil {
+		log.Debugf("Cannot start queued job %s: %v\n", j.id, err)
+	}
+}
+
+func labelSlot(key, value string) string {
+	return fmt.Sprintf("%s=%s", key, value)
+}
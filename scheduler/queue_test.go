@@ -0,0 +1,185 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+// QueueRunner runs the jobs without isolating them, so that they're running for as long as the actual commands
+const QueueRunner = "../bin/run.sh"
+
+func TestSchedulerMaxRunning(t *testing.T) {
+	s := New(QueueRunner, WithMaxRunning(1))
+
+	id1, _ := s.Start("sleep", 0, "0.2")
+	id2, _ := s.Start("sleep", 0, "0")
+
+	assertSchedulerStatus(t, s, id1, Running, -1)
+	assertSchedulerStatus(t, s, id2, Queued, -1)
+	assertQueued(t, s, id2)
+
+	s.Wait()
+
+	assertSchedulerStatus(t, s, id1, Exited, 0)
+	assertSchedulerStatus(t, s, id2, Exited, 0)
+	assertQueued(t, s)
+}
+
+func TestSchedulerMaxRunningPerLabel(t *testing.T) {
+	s := New(QueueRunner, WithMaxRunningPerLabel("tenant", 1))
+
+	id1, _ := s.StartJob(&JobSpec{Executable: "sleep", Args: []string{"0.2"}, Labels: map[string]string{"tenant": "a"}})
+	id2, _ := s.StartJob(&JobSpec{Executable: "sleep", Args: []string{"0.2"}, Labels: map[string]string{"tenant": "a"}})
+	id3, _ := s.StartJob(&JobSpec{Executable: "sleep", Args: []string{"0.2"}, Labels: map[string]string{"tenant": "b"}})
+
+	assertSchedulerStatus(t, s, id1, Running, -1)
+	assertSchedulerStatus(t, s, id2, Queued, -1)
+	assertSchedulerStatus(t, s, id3, Running, -1)
+
+	s.Wait()
+
+	assertSchedulerStatus(t, s, id2, Exited, 0)
+}
+
+func TestSchedulerCancel(t *testing.T) {
+	s := New(QueueRunner, WithMaxRunning(1))
+
+	id1, _ := s.Start("sleep", 0, "0.1")
+	id2, _ := s.Start("sleep", 0, "0")
+
+	st, err := s.Cancel(id2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertStatus(t, st, Cancelled, -1)
+	assertQueued(t, s)
+
+	if _, err := s.Cancel(id1); err == nil {
+		t.Fatalf("A running job cannot be cancelled")
+	}
+
+	if _, err := s.Cancel("unknown"); err == nil {
+		t.Fatalf("An unknown job cannot be cancelled")
+	}
+
+	// The output of a cancelled job is closed
+	o, _ := s.Output(id2)
+	select {
+	case _, ok := <-o.Read():
+		if ok {
+			t.Fatalf("A cancelled job should have no output")
+		}
+	case <-time.After(100 * time.Millisecond):
+		t.Fatalf("The output of a cancelled job should be closed")
+	}
+
+	s.Wait()
+
+	assertSchedulerStatus(t, s, id1, Exited, 0)
+	assertSchedulerStatus(t, s, id2, Cancelled, -1)
+}
+
+func TestSchedulerPriority(t *testing.T) {
+	s := New(QueueRunner, WithMaxRunning(1))
+
+	_, _ = s.Start("sleep", 0, "0.1")
+	low, _ := s.StartJob(&JobSpec{Executable: "sleep", Args: []string{"0"}})
+	high, _ := s.StartJob(&JobSpec{Executable: "sleep", Args: []string{"0"}, Priority: 10})
+
+	assertQueued(t, s, high, low)
+
+	s.Wait()
+}
+
+func TestSchedulerPreemption(t *testing.T) {
+	// Without the runner forwarding it, SIGTERM is ignored by the job (that's the init process of its PID namespace),
+	// so it's killed after the grace period
+	s := New(QueueRunner, WithMaxRunning(1), WithPreemption(20*time.Millisecond))
+
+	low, _ := s.StartJob(&JobSpec{Executable: "sh", Args: []string{"-c", "echo started; exec sleep 0.3"}})
+	time.Sleep(50 * time.Millisecond)
+
+	high, _ := s.StartJob(&JobSpec{Executable: "sleep", Args: []string{"0.1"}, Priority: 10})
+
+	assertSchedulerStatus(t, s, low, Preempted, -1)
+
+	time.Sleep(60 * time.Millisecond)
+
+	assertSchedulerStatus(t, s, high, Running, -1)
+	assertQueued(t, s, low)
+
+	s.Wait()
+
+	assertSchedulerStatus(t, s, high, Exited, 0)
+	assertSchedulerStatus(t, s, low, Exited, 0)
+
+	// The output of the preempted job is kept, and the new one is appended
+	assertSchedulerOutput(t, s, low, []string{"started", "started"})
+}
+
+func TestSchedulerPreemptedJobStoppingGracefully(t *testing.T) {
+	s := New(QueueRunner, WithMaxRunning(1), WithPreemption(time.Second))
+
+	// The job handles SIGTERM, exiting successfully
+	low, _ := s.StartJob(&JobSpec{Executable: "sh", Args: []string{"-c", "trap 'exit 0' TERM; echo started; sleep 0.3 & wait"}})
+	time.Sleep(50 * time.Millisecond)
+
+	high, _ := s.StartJob(&JobSpec{Executable: "sleep", Args: []string{"0.1"}, Priority: 10})
+
+	// The job has left room without waiting for the grace period
+	time.Sleep(50 * time.Millisecond)
+	assertSchedulerStatus(t, s, high, Running, -1)
+	assertQueued(t, s, low)
+
+	s.Wait()
+
+	assertSchedulerStatus(t, s, high, Exited, 0)
+	assertSchedulerStatus(t, s, low, Exited, 0)
+	assertSchedulerOutput(t, s, low, []string{"started", "started"})
+}
+
+func TestSchedulerPreemptionThroughRunner(t *testing.T) {
+	// The runner forwards SIGTERM to the job, that's terminated by it
+	s := NewSelf(WithMaxRunning(1), WithPreemption(time.Second))
+
+	low, _ := s.StartJob(&JobSpec{Executable: "sh", Args: []string{"-c", "echo started; sleep 0.3"}})
+	time.Sleep(100 * time.Millisecond)
+
+	high, _ := s.StartJob(&JobSpec{Executable: "sleep", Args: []string{"0.1"}, Priority: 10})
+
+	time.Sleep(100 * time.Millisecond)
+	assertSchedulerStatus(t, s, high, Running, -1)
+	assertQueued(t, s, low)
+
+	s.Wait()
+
+	assertSchedulerStatus(t, s, high, Exited, 0)
+	assertSchedulerStatus(t, s, low, Exited, 0)
+	assertSchedulerOutput(t, s, low, []string{"started", "started"})
+}
+
+func TestSchedulerNoPreemptionOfHigherPriority(t *testing.T) {
+	s := New(QueueRunner, WithMaxRunning(1), WithPreemption(time.Second))
+
+	running, _ := s.StartJob(&JobSpec{Executable: "sleep", Args: []string{"0.1"}, Priority: 10})
+	queued, _ := s.StartJob(&JobSpec{Executable: "sleep", Args: []string{"0"}, Priority: 5})
+
+	assertSchedulerStatus(t, s, running, Running, -1)
+	assertSchedulerStatus(t, s, queued, Queued, -1)
+
+	s.Wait()
+}
+
+func assertQueued(t *testing.T, s *Scheduler, expected ...string) {
+	queued := s.Queued()
+
+	if len(queued) != len(expected) {
+		t.Fatalf("Expected queued jobs %q, got %q", expected, queued)
+	}
+
+	for i, id := range expected {
+		if queued[i] != id {
+			t.Fatalf("Expected queued jobs %q, got %q", expected, queued)
+		}
+	}
+}
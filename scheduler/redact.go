@@ -0,0 +1,193 @@
+package scheduler
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/beoboo/job-scheduler/library/logsync"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// REDACTION_MASK replaces the secrets found in the output of a job
+	REDACTION_MASK = "***"
+	// REDACTION_FLUSH_TIMEOUT is how long the data that could be the beginning of a secret is held, without a
+	// FlushTimeout
+	REDACTION_FLUSH_TIMEOUT = 100 * time.Millisecond
+)
+
+// redactor masks the secrets of a job in its output, before passing it to the next framer.
+// Since a secret can be split between two chunks read from the output, the data that could be the beginning of
+// a secret is held until the next write (or close):
+// - for values, only the trailing bytes matching the beginning of one of them;
+// - for patterns, everything after the last newline (so patterns are not matched across lines).
+// The held data is flushed after the FlushTimeout of the job (or REDACTION_FLUSH_TIMEOUT), so that a partial line
+// (i.e. a prompt) is not held forever. A secret written across a longer pause can be only partially masked.
+type redactor struct {
+	next     framer
+	secrets  []string
+	re       *regexp.Regexp
+	patterns bool
+	timeout  time.Duration
+	buf      []byte
+	timer    *time.Timer
+	m        logsync.Mutex
+}
+
+// newRedactor returns a redactor for the secrets of spec, or nil if there are none
+func newRedactor(spec *JobSpec, next framer) *redactor {
+	re, err := spec.secretsRegexp()
+	if err != nil || re == nil {
+		// The spec has already been validated
+		return nil
+	}
+
+	timeout := spec.FlushTimeout
+	if timeout <= 0 {
+		timeout = REDACTION_FLUSH_TIMEOUT
+	}
+
+	return &redactor{
+		next:     next,
+		secrets:  spec.Secrets,
+		re:       re,
+		patterns: len(spec.SecretPatterns) > 0,
+		timeout:  timeout,
+		m:        logsync.NewMutex("redactor"),
+	}
+}
+
+func (r *redactor) write(p []byte) error {
+	r.m.WLock("write")
+	defer r.m.WUnlock("write")
+
+	r.buf = append(r.buf, p...)
+
+	cut := len(r.buf) - r.partialSecret()
+
+	if r.patterns && len(r.buf) < MAX_LINE_LENGTH {
+		// The buffer is bounded anyway, if the output has no newlines
+		if n := bytes.LastIndexByte(r.buf[:cut], '\n') + 1; n < cut {
+			cut = n
+		}
+	}
+
+	// A secret found across the cut is complete, so it can be emitted
+	for _, m := range r.re.FindAllIndex(r.buf, -1) {
+		if m[0] < cut && cut < m[1] {
+			cut = m[1]
+		}
+	}
+
+	if cut > 0 {
+		if err := r.emit(cut); err != nil {
+			return err
+		}
+	}
+
+	if len(r.buf) == 0 {
+		r.stopTimer()
+	} else if r.timer == nil {
+		r.timer = time.AfterFunc(r.timeout, r.flush)
+	}
+
+	return nil
+}
+
+// close emits the pending data
+func (r *redactor) close() error {
+	r.m.WLock("close")
+	defer r.m.WUnlock("close")
+
+	r.stopTimer()
+
+	if len(r.buf) == 0 {
+		return nil
+	}
+
+	return r.emit(len(r.buf))
+}
+
+// flush emits the held data after the timeout, flushing the next framer too
+func (r *redactor) flush() {
+	r.m.WLock("flush")
+	r.timer = nil
+	if len(r.buf) > 0 {
+		_ = r.emit(len(r.buf))
+	}
+	r.m.WUnlock("flush")
+
+	r.next.flush()
+}
+
+// emit masks the secrets in the first n bytes of the buffer, and passes them to the next framer
+func (r *redactor) emit(n int) error {
+	text := r.re.ReplaceAll(r.buf[:n], []byte(REDACTION_MASK))
+	r.buf = append([]byte{}, r.buf[n:]...)
+
+	return r.next.write(text)
+}
+
+func (r *redactor) stopTimer() {
+	if r.timer != nil {
+		r.timer.Stop()
+		r.timer = nil
+	}
+}
+
+// partialSecret returns the length of the longest suffix of the buffer that's the beginning of a secret value
+func (r *redactor) partialSecret() int {
+	longest := 0
+
+	for _, secret := range r.secrets {
+		n := len(secret) - 1
+		if n > len(r.buf) {
+			n = len(r.buf)
+		}
+
+		for ; n > longest; n-- {
+			if bytes.HasSuffix(r.buf, []byte(secret[:n])) {
+				longest = n
+				break
+			}
+		}
+	}
+
+	return longest
+}
+
+// secretsRegexp returns a regexp matching all the secrets of the spec (or nil if there are none).
+// Longer values come first, so that they're preferred to the ones they start with.
+func (s *JobSpec) secretsRegexp() (*regexp.Regexp, error) {
+	if len(s.Secrets) == 0 && len(s.SecretPatterns) == 0 {
+		return nil, nil
+	}
+
+	secrets := append([]string{}, s.Secrets...)
+	sort.Slice(secrets, func(i, j int) bool {
+		return len(secrets[i]) > len(secrets[j])
+	})
+
+	var alternatives []string
+	for _, secret := range secrets {
+		if secret == "" {
+			return nil, fmt.Errorf("empty secret")
+		}
+		alternatives = append(alternatives, regexp.QuoteMeta(secret))
+	}
+
+	for _, pattern := range s.SecretPatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid secret pattern \"%s\": %v", pattern, err)
+		}
+		if re.MatchString("") {
+			return nil, fmt.Errorf("invalid secret pattern \"%s\": it matches an empty string", pattern)
+		}
+		alternatives = append(alternatives, "(?:"+pattern+")")
+	}
+
+	return regexp.Compile(strings.Join(alternatives, "|"))
+}
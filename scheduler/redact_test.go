@@ -0,0 +1,107 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRedactor(t *testing.T) {
+	lines, r := newTestRedactor(&JobSpec{Secrets: []string{"hunter2"}})
+
+	_ = r.write([]byte("password: hunter2\n"))
+	_ = r.close()
+
+	lines.assert(t, "password: ***\n")
+}
+
+func TestRedactorSecretAcrossChunks(t *testing.T) {
+	lines, r := newTestRedactor(&JobSpec{Secrets: []string{"hunter2"}})
+
+	_ = r.write([]byte("password: hun"))
+	_ = r.write([]byte("ter2, again: h"))
+	_ = r.write([]byte("unter2"))
+	_ = r.close()
+
+	lines.assert(t, "password: ", "***, again: ", "***")
+}
+
+func TestRedactorPartialSecret(t *testing.T) {
+	lines, r := newTestRedactor(&JobSpec{Secrets: []string{"hunter2"}})
+
+	_ = r.write([]byte("hunt"))
+	_ = r.write([]byte("ing"))
+	_ = r.close()
+
+	lines.assert(t, "hunting")
+}
+
+func TestRedactorLongestSecret(t *testing.T) {
+	lines, r := newTestRedactor(&JobSpec{Secrets: []string{"abc", "abcdef"}})
+
+	_ = r.write([]byte("abcdef abc"))
+	_ = r.close()
+
+	// The trailing "abc" is held, since it could be the beginning of "abcdef"
+	lines.assert(t, "*** ", "***")
+}
+
+func TestRedactorPattern(t *testing.T) {
+	lines, r := newTestRedactor(&JobSpec{SecretPatterns: []string{`token=\w+`}})
+
+	_ = r.write([]byte("a token=12"))
+	_ = r.write([]byte("34\nb"))
+	_ = r.close()
+
+	lines.assert(t, "a ***\n", "b")
+}
+
+func TestRedactorFlushTimeout(t *testing.T) {
+	lines, r := newTestRedactor(&JobSpec{SecretPatterns: []string{`token=\w+`}, FlushTimeout: 10 * time.Millisecond})
+
+	_ = r.write([]byte("token: "))
+
+	time.Sleep(50 * time.Millisecond)
+	lines.assert(t, "token: ")
+
+	_ = r.write([]byte("token=1234\n"))
+	_ = r.close()
+
+	lines.assert(t, "token: ", "***\n")
+}
+
+func TestRedactorInvalidSecrets(t *testing.T) {
+	for _, spec := range []*JobSpec{
+		{Executable: "echo", Secrets: []string{""}},
+		{Executable: "echo", SecretPatterns: []string{"("}},
+		{Executable: "echo", SecretPatterns: []string{"a*"}},
+	} {
+		if err := spec.validate(); err == nil {
+			t.Fatalf("Spec with secrets %q and patterns %q should be invalid", spec.Secrets, spec.SecretPatterns)
+		}
+	}
+}
+
+func TestJobRedactsSecrets(t *testing.T) {
+	j := newJobFromSpec(&JobSpec{Framing: FramingLines, Secrets: []string{"hunter2"}}, &wg)
+
+	err := j.startIsolated("printf", 0, "password: hunter2\\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wg.Wait()
+
+	assertJobOutput(t, j, []string{"password: ***\n"})
+}
+
+func newTestRedactor(spec *JobSpec) (*testLines, *redactor) {
+	lines := &testLines{}
+
+	return lines, newRedactor(spec, &rawFramer{emit: func(text []byte) error {
+		lines.m.Lock()
+		defer lines.m.Unlock()
+
+		lines.lines = append(lines.lines, string(text))
+		return nil
+	}})
+}
@@ -0,0 +1,133 @@
+package scheduler
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// maxHistory is the max number of attempts (and restarts, when there's no limit on them) kept for a job
+	maxHistory = 64
+	// RESTART_MAX_BACKOFF is the default max delay before a restart
+	RESTART_MAX_BACKOFF = 5 * time.Minute
+)
+
+// RestartMode sets when a job is restarted once it's over
+type RestartMode int
+
+const (
+	// RestartNever leaves the job in its final status
+	RestartNever RestartMode = 0
+	// RestartOnFailure restarts the job when it's errored (that's a non-zero exit code or a failure)
+	RestartOnFailure RestartMode = 1
+	// RestartAlways restarts the job whenever it's over, unless it's been stopped
+	RestartAlways RestartMode = 2
+)
+
+// RestartPolicy keeps a long-running job up, restarting it once it's over (after its retries, if any).
+// Every incarnation is a run of the same job, whose output is appended to the one of the previous ones.
+type RestartPolicy struct {
+	Mode RestartMode
+	// MaxRestarts is the max number of restarts within Window (or ever, if Window is 0), after which the job is left
+	// in its final status (0 means no limit)
+	MaxRestarts int
+	Window      time.Duration
+	// Backoff is the delay before a restart, doubled for every other restart within Window, up to MaxBackoff
+	// (RESTART_MAX_BACKOFF if 0). A run lasting at least MaxBackoff is stable, and resets the delay to Backoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// validate checks that the policy can be used
+func (p *RestartPolicy) validate() error {
+	if p.Mode < RestartNever || p.Mode > RestartAlways {
+		return fmt.Errorf("invalid restart mode %d", p.Mode)
+	}
+
+	if p.MaxRestarts < 0 || p.Window < 0 || p.Backoff < 0 || p.MaxBackoff < 0 {
+		return fmt.Errorf("invalid negative restart policy")
+	}
+
+	return nil
+}
+
+// restarts checks if a job that's over with a status has to be restarted, given the times of its previous restarts
+func (p *RestartPolicy) restarts(st StatusType, restarts []time.Time, now time.Time) bool {
+	switch {
+	case p.Mode == RestartNever:
+		return false
+	case p.Mode == RestartOnFailure && st != Errored:
+		return false
+	}
+
+	return p.MaxRestarts == 0 || len(p.recent(restarts, now)) < p.MaxRestarts
+}
+
+// backoff returns the delay before a restart, given the times of the previous ones and the time the last stable
+// run was over at (the restarts before it are not considered)
+func (p *RestartPolicy) backoff(restarts []time.Time, stable time.Time, now time.Time) time.Duration {
+	max := p.maxBackoff()
+
+	d := p.Backoff
+	for _, t := range p.recent(restarts, now) {
+		if !t.After(stable) {
+			continue
+		}
+		// The delay saturates at the max one, without overflowing
+		if d >= max/2 {
+			return max
+		}
+		d *= 2
+	}
+
+	if d > max {
+		return max
+	}
+
+	return d
+}
+
+// stable checks if a run lasting d resets the backoff
+func (p *RestartPolicy) stable(d time.Duration) bool {
+	return d >= p.maxBackoff()
+}
+
+func (p *RestartPolicy) maxBackoff() time.Duration {
+	if p.MaxBackoff == 0 {
+		return RESTART_MAX_BACKOFF
+	}
+
+	return p.MaxBackoff
+}
+
+// record adds a restart at now to the previous ones, dropping the ones that can't affect the following restarts
+// (out of the window, or beyond the max number of them)
+func (p *RestartPolicy) record(restarts []time.Time, now time.Time) []time.Time {
+	restarts = append(p.recent(restarts, now), now)
+
+	keep := maxHistory
+	if p.MaxRestarts > keep {
+		keep = p.MaxRestarts
+	}
+
+	if len(restarts) > keep {
+		restarts = append([]time.Time{}, restarts[len(restarts)-keep:]...)
+	}
+
+	return restarts
+}
+
+// recent returns the restarts within the window before now (all of them, if there's no window)
+func (p *RestartPolicy) recent(restarts []time.Time, now time.Time) []time.Time {
+	if p.Window == 0 {
+		return restarts
+	}
+
+	for i, t := range restarts {
+		if now.Sub(t) < p.Window {
+			return restarts[i:]
+		}
+	}
+
+	return nil
+}
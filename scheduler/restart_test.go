@@ -0,0 +1,200 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRestartPolicyRestarts(t *testing.T) {
+	now := time.Now()
+	restarts := []time.Time{now.Add(-2 * time.Minute), now.Add(-30 * time.Second)}
+
+	tests := []struct {
+		policy   RestartPolicy
+		st       StatusType
+		expected bool
+	}{
+		{RestartPolicy{}, Errored, false},
+		{RestartPolicy{Mode: RestartOnFailure}, Errored, true},
+		{RestartPolicy{Mode: RestartOnFailure}, Exited, false},
+		{RestartPolicy{Mode: RestartAlways}, Exited, true},
+		{RestartPolicy{Mode: RestartAlways, MaxRestarts: 2}, Exited, false},
+		{RestartPolicy{Mode: RestartAlways, MaxRestarts: 2, Window: time.Minute}, Exited, true},
+		{RestartPolicy{Mode: RestartAlways, MaxRestarts: 1, Window: time.Minute}, Exited, false},
+	}
+
+	for _, test := range tests {
+		if test.policy.restarts(test.st, restarts, now) != test.expected {
+			t.Fatalf("Policy %+v should restart %s: %v", test.policy, test.st, test.expected)
+		}
+	}
+}
+
+func TestRestartPolicyBackoff(t *testing.T) {
+	now := time.Now()
+	p := RestartPolicy{Backoff: time.Second, MaxBackoff: 5 * time.Second, Window: time.Minute}
+
+	tests := []struct {
+		restarts []time.Time
+		expected time.Duration
+	}{
+		{nil, time.Second},
+		{[]time.Time{now}, 2 * time.Second},
+		{[]time.Time{now, now}, 4 * time.Second},
+		{[]time.Time{now, now, now}, 5 * time.Second},
+		// The restarts out of the window are not considered
+		{[]time.Time{now.Add(-time.Hour), now.Add(-time.Hour), now}, 2 * time.Second},
+	}
+
+	for _, test := range tests {
+		if d := p.backoff(test.restarts, time.Time{}, now); d != test.expected {
+			t.Fatalf("Expected backoff %s after %d restarts, got %s", test.expected, len(test.restarts), d)
+		}
+	}
+}
+
+func TestRestartPolicyBackoffWithoutWindow(t *testing.T) {
+	now := time.Now()
+	restarts := make([]time.Time, maxHistory)
+	for i := range restarts {
+		restarts[i] = now.Add(-time.Duration(maxHistory-i) * time.Hour)
+	}
+
+	// The delay is capped by default, without overflowing
+	p := RestartPolicy{Backoff: time.Second}
+	if d := p.backoff(restarts, time.Time{}, now); d != RESTART_MAX_BACKOFF {
+		t.Fatalf("Expected backoff %s, got %s", RESTART_MAX_BACKOFF, d)
+	}
+
+	// The restarts before a stable run are not considered
+	if d := p.backoff(restarts, restarts[maxHistory-2], now); d != 2*time.Second {
+		t.Fatalf("Expected backoff %s after a stable run, got %s", 2*time.Second, d)
+	}
+
+	if !p.stable(RESTART_MAX_BACKOFF) || p.stable(time.Minute) {
+		t.Fatalf("Expected a run to be stable after %s", RESTART_MAX_BACKOFF)
+	}
+}
+
+func TestRestartPolicyRecord(t *testing.T) {
+	now := time.Now()
+
+	// The restarts out of the window are dropped
+	p := RestartPolicy{Window: time.Minute}
+	restarts := p.record([]time.Time{now.Add(-2 * time.Minute), now.Add(-time.Second)}, now)
+	if len(restarts) != 2 {
+		t.Fatalf("Expected 2 restarts, got %d", len(restarts))
+	}
+
+	// Without a window, the restarts are only kept up to the max number of them
+	for _, test := range []struct {
+		policy   RestartPolicy
+		expected int
+	}{
+		{RestartPolicy{}, maxHistory},
+		{RestartPolicy{MaxRestarts: 2 * maxHistory}, 2 * maxHistory},
+	} {
+		restarts = nil
+		for i := 0; i < 3*maxHistory; i++ {
+			restarts = test.policy.record(restarts, now)
+		}
+
+		if len(restarts) != test.expected {
+			t.Fatalf("Policy %+v should keep %d restarts, got %d", test.policy, test.expected, len(restarts))
+		}
+	}
+}
+
+func TestSchedulerRestartOnFailure(t *testing.T) {
+	s := New(QueueRunner)
+
+	id, _ := s.StartJob(&JobSpec{
+		Executable: "sh",
+		Args:       []string{"-c", "echo run; exit 1"},
+		Restart:    RestartPolicy{Mode: RestartOnFailure, MaxRestarts: 2},
+	})
+
+	s.Wait()
+
+	st, _ := s.Status(id)
+	assertStatus(t, st, Errored, 1)
+	if st.Restarts != 2 {
+		t.Fatalf("Expected 2 restarts, got %d", st.Restarts)
+	}
+
+	// The output of every incarnation is kept, with a line marking the restarts
+	assertSchedulerOutput(t, s, id, []string{"run", "Restart 1", "run", "Restart 2", "run"})
+
+	// A successful job is not restarted on failure
+	id, _ = s.StartJob(&JobSpec{
+		Executable: "true",
+		Restart:    RestartPolicy{Mode: RestartOnFailure, MaxRestarts: 2},
+	})
+
+	s.Wait()
+
+	st, _ = s.Status(id)
+	assertStatus(t, st, Exited, 0)
+	if st.Restarts != 0 {
+		t.Fatalf("Expected no restarts, got %d", st.Restarts)
+	}
+}
+
+func TestSchedulerRestartAlways(t *testing.T) {
+	s := New(QueueRunner)
+
+	id, _ := s.StartJob(&JobSpec{
+		Executable: "true",
+		Restart:    RestartPolicy{Mode: RestartAlways, MaxRestarts: 3, Window: time.Minute},
+	})
+
+	s.Wait()
+
+	st, _ := s.Status(id)
+	assertStatus(t, st, Exited, 0)
+	if st.Restarts != 3 {
+		t.Fatalf("Expected 3 restarts, got %d", st.Restarts)
+	}
+}
+
+func TestSchedulerRestartWindow(t *testing.T) {
+	s, c := newScheduledScheduler()
+
+	id, _ := s.StartJob(&JobSpec{
+		Executable: "true",
+		Restart:    RestartPolicy{Mode: RestartAlways, MaxRestarts: 1, Window: time.Minute, Backoff: time.Hour},
+	})
+
+	waitSchedulerStatus(t, s, id, Scheduled)
+
+	// The previous restart is out of the window, once the backoff is over
+	c.Advance(time.Hour)
+	waitSchedulerStatus(t, s, id, Scheduled)
+
+	st, _ := s.Status(id)
+	if st.Restarts != 2 {
+		t.Fatalf("Expected 2 restarts, got %d", st.Restarts)
+	}
+
+	_, _ = s.Cancel(id)
+	s.Wait()
+}
+
+func TestSchedulerStopIsNotRestarted(t *testing.T) {
+	s := New(QueueRunner)
+
+	id, _ := s.StartJob(&JobSpec{
+		Executable: "sleep",
+		Args:       []string{"10"},
+		Restart:    RestartPolicy{Mode: RestartAlways},
+	})
+
+	_, _ = s.Stop(id)
+	s.Wait()
+
+	st, _ := s.Status(id)
+	assertStatus(t, st, Killed, -1)
+	if st.Restarts != 0 {
+		t.Fatalf("Expected no restarts, got %d", st.Restarts)
+	}
+}
@@ -0,0 +1,120 @@
+package scheduler
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy sets how a failed job is retried. All the attempts are runs of the same job, whose output is appended
+// to the one of the previous attempts.
+type RetryPolicy struct {
+	// MaxAttempts is the max number of runs, including the first one (0 or 1 means no retries)
+	MaxAttempts int
+	// Backoff is the delay before the first retry, multiplied by Multiplier (2 if 0) for every following one,
+	// up to MaxBackoff (if set)
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	Multiplier float64
+	// Jitter randomizes every delay by up to this fraction of it (i.e. 0.1 for +/-10%)
+	Jitter float64
+	// Statuses are the final statuses retried, among Errored (the default, that's a non-zero exit code or a failure)
+	// and Exited (a stopped job is never retried), and ExitCodes restricts them to the given exit codes
+	Statuses  []StatusType
+	ExitCodes []int
+}
+
+// Attempt describes a run of a job
+type Attempt struct {
+	ExitCode int
+	Signal   syscall.Signal
+	Reason   ExitReason
+	Error    string
+	Started  time.Time
+	Finished time.Time
+}
+
+// validate checks that the policy can be used
+func (p *RetryPolicy) validate() error {
+	if p.MaxAttempts < 0 || p.Backoff < 0 || p.MaxBackoff < 0 || p.Multiplier < 0 {
+		return fmt.Errorf("invalid negative retry policy")
+	}
+
+	if p.Jitter < 0 || p.Jitter > 1 {
+		return fmt.Errorf("invalid retry jitter %f, expected between 0 and 1", p.Jitter)
+	}
+
+	for _, st := range p.Statuses {
+		if st != Errored && st != Exited {
+			return fmt.Errorf("invalid retry status \"%s\", expected errored or exited", st)
+		}
+	}
+
+	return nil
+}
+
+// retries checks if a job that's finished its attempt with a status and an exit code has to be retried
+func (p *RetryPolicy) retries(st StatusType, exitCode int, attempt int) bool {
+	if attempt >= p.MaxAttempts {
+		return false
+	}
+
+	statuses := p.Statuses
+	if len(statuses) == 0 {
+		statuses = []StatusType{Errored}
+	}
+
+	if !containsStatus(statuses, st) {
+		return false
+	}
+
+	if len(p.ExitCodes) == 0 {
+		return true
+	}
+
+	for _, code := range p.ExitCodes {
+		if code == exitCode {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsStatus(statuses []StatusType, st StatusType) bool {
+	for _, s := range statuses {
+		if s == st {
+			return true
+		}
+	}
+
+	return false
+}
+
+// backoff returns the delay before an attempt (the second one is the first retry)
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier == 0 {
+		multiplier = 2
+	}
+
+	if p.Backoff == 0 {
+		return 0
+	}
+
+	// The delay is clamped before converting it, since it can grow beyond the max duration
+	d := float64(p.Backoff) * math.Pow(multiplier, float64(attempt-2))
+	if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+
+	d += d * p.Jitter * (2*rand.Float64() - 1)
+
+	if d >= math.MaxInt64 {
+		return math.MaxInt64
+	}
+
+	return time.Duration(d)
+}
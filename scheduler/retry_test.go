@@ -0,0 +1,156 @@
+package scheduler
+
+import (
+	"github.com/beoboo/job-scheduler/library/stream"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyRetries(t *testing.T) {
+	tests := []struct {
+		policy   RetryPolicy
+		st       StatusType
+		exitCode int
+		attempt  int
+		expected bool
+	}{
+		{RetryPolicy{}, Errored, 1, 1, false},
+		{RetryPolicy{MaxAttempts: 2}, Errored, 1, 1, true},
+		{RetryPolicy{MaxAttempts: 2}, Errored, 1, 2, false},
+		{RetryPolicy{MaxAttempts: 2}, Exited, 0, 1, false},
+		{RetryPolicy{MaxAttempts: 2}, Killed, -1, 1, false},
+		{RetryPolicy{MaxAttempts: 2, ExitCodes: []int{2}}, Errored, 1, 1, false},
+		{RetryPolicy{MaxAttempts: 2, ExitCodes: []int{2}}, Errored, 2, 1, true},
+		{RetryPolicy{MaxAttempts: 2, Statuses: []StatusType{Exited}}, Errored, 1, 1, false},
+		{RetryPolicy{MaxAttempts: 2, Statuses: []StatusType{Exited}}, Exited, 0, 1, true},
+	}
+
+	for _, test := range tests {
+		if test.policy.retries(test.st, test.exitCode, test.attempt) != test.expected {
+			t.Fatalf("Policy %+v should retry %s (%d) at attempt %d: %v", test.policy, test.st, test.exitCode,
+				test.attempt, test.expected)
+		}
+	}
+}
+
+func TestRetryPolicyNotValid(t *testing.T) {
+	for _, policy := range []RetryPolicy{
+		{MaxAttempts: -1},
+		{MaxAttempts: 2, Jitter: 2},
+		{MaxAttempts: 2, Statuses: []StatusType{Killed}},
+	} {
+		if err := policy.validate(); err == nil {
+			t.Fatalf("Policy %+v should not be valid", policy)
+		}
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := RetryPolicy{Backoff: time.Second, MaxBackoff: 5 * time.Second}
+
+	for attempt, expected := range map[int]time.Duration{2: time.Second, 3: 2 * time.Second, 4: 4 * time.Second, 5: 5 * time.Second} {
+		if d := p.backoff(attempt); d != expected {
+			t.Fatalf("Expected backoff %s before attempt %d, got %s", expected, attempt, d)
+		}
+	}
+
+	p = RetryPolicy{Backoff: time.Second, Jitter: 0.5}
+	for i := 0; i < 100; i++ {
+		if d := p.backoff(2); d < 500*time.Millisecond || d > 1500*time.Millisecond {
+			t.Fatalf("Expected backoff within 50%% of %s, got %s", time.Second, d)
+		}
+	}
+
+	// Without a max, the delay doesn't overflow
+	p = RetryPolicy{Backoff: time.Second}
+	if d := p.backoff(100); d != math.MaxInt64 {
+		t.Fatalf("Expected backoff %s before attempt %d, got %s", time.Duration(math.MaxInt64), 100, d)
+	}
+}
+
+func TestSchedulerRetry(t *testing.T) {
+	s, c := newScheduledScheduler()
+
+	id, _ := s.StartJob(&JobSpec{
+		Executable: "sh",
+		Args:       []string{"-c", "echo run; exit 3"},
+		Retry:      RetryPolicy{MaxAttempts: 3, Backoff: 10 * time.Second},
+	})
+
+	waitSchedulerStatus(t, s, id, Scheduled)
+	c.Advance(10 * time.Second)
+
+	waitSchedulerStatus(t, s, id, Scheduled)
+	c.Advance(20 * time.Second)
+
+	s.Wait()
+
+	st, _ := s.Status(id)
+	assertStatus(t, st, Errored, 3)
+	if st.Attempt != 3 || len(st.Attempts) != 3 {
+		t.Fatalf("Expected 3 attempts, got %d (%d)", st.Attempt, len(st.Attempts))
+	}
+	for _, attempt := range st.Attempts {
+		if attempt.ExitCode != 3 {
+			t.Fatalf("Expected every attempt to exit with 3, got %d", attempt.ExitCode)
+		}
+	}
+
+	// The output of every attempt is kept, with a line marking the retries
+	assertSchedulerOutput(t, s, id, []string{"run", "Attempt 2 of 3", "run", "Attempt 3 of 3", "run"})
+
+	o, _ := s.Output(id)
+	events := 0
+	for range o.Read(stream.OfType(stream.Event)) {
+		events++
+	}
+	if events != 2 {
+		t.Fatalf("Expected 2 event lines, got %d", events)
+	}
+}
+
+func TestSchedulerRetryNotRetryable(t *testing.T) {
+	s, _ := newScheduledScheduler()
+
+	id, _ := s.StartJob(&JobSpec{
+		Executable: "sh",
+		Args:       []string{"-c", "exit 3"},
+		Retry:      RetryPolicy{MaxAttempts: 3, ExitCodes: []int{1}},
+	})
+
+	s.Wait()
+
+	st, _ := s.Status(id)
+	assertStatus(t, st, Errored, 3)
+	if st.Attempt != 1 {
+		t.Fatalf("Expected 1 attempt, got %d", st.Attempt)
+	}
+}
+
+func TestSchedulerStopIsNotRetried(t *testing.T) {
+	s := New(QueueRunner)
+
+	id, _ := s.StartJob(&JobSpec{
+		Executable: "sleep",
+		Args:       []string{"10"},
+		Retry:      RetryPolicy{MaxAttempts: 3},
+	})
+
+	_, _ = s.Stop(id)
+	s.Wait()
+
+	assertSchedulerStatus(t, s, id, Killed, -1)
+}
+
+func waitSchedulerStatus(t *testing.T, s *Scheduler, id string, expected StatusType) {
+	for i := 0; i < 100; i++ {
+		st, _ := s.Status(id)
+		if st.Type == expected {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	assertSchedulerStatus(t, s, id, expected, -1)
+}
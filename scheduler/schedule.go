@@ -0,0 +1,273 @@
+package scheduler
+
+import (
+	"fmt"
+	"github.com/beoboo/job-scheduler/library/errors"
+	"github.com/beoboo/job-scheduler/library/log"
+	"github.com/beoboo/job-scheduler/library/logsync"
+	"time"
+)
+
+const (
+	// ScheduleLabel is the label linking the jobs started by a schedule to it
+	ScheduleLabel = "schedule"
+)
+
+// OverlapPolicy sets what happens when a schedule fires while its previous run is not over
+type OverlapPolicy int
+
+const (
+	// OverlapAllow starts a new run anyway
+	OverlapAllow OverlapPolicy = 0
+	// OverlapSkip skips the new run
+	OverlapSkip OverlapPolicy = 1
+	// OverlapReplace stops the previous run, and starts the new one
+	OverlapReplace OverlapPolicy = 2
+)
+
+// ScheduleSpec describes a job run periodically by the Scheduler
+type ScheduleSpec struct {
+	// Cron is the expression setting when the job runs: "SECOND MINUTE HOUR DAY_OF_MONTH MONTH DAY_OF_WEEK"
+	// (see parseCron)
+	Cron string
+	// TimeZone is the IANA name of the time zone of the expression (if empty, the local one is used)
+	TimeZone string
+	Overlap  OverlapPolicy
+	Job      JobSpec
+}
+
+// ScheduleStatus describes the state of a schedule, and the jobs it started (oldest first)
+type ScheduleStatus struct {
+	Cron    string
+	Overlap OverlapPolicy
+	Paused  bool
+	Next    time.Time
+	Runs    []string
+	Skipped int
+}
+
+// schedule starts a job every time its cron expression matches
+type schedule struct {
+	id      string
+	spec    *ScheduleSpec
+	expr    *cronExpr
+	loc     *time.Location
+	s       *Scheduler
+	paused  bool
+	removed bool
+	next    time.Time
+	timer   timer
+	runs    []string
+	skipped int
+	m       logsync.Mutex
+}
+
+// Schedule registers a job to be run periodically, returning the ID of the schedule, or an error if the spec is
+// not valid.
+// Every run is a normal job, labelled with the ID of the schedule (see ScheduleLabel).
+func (s *Scheduler) Schedule(spec *ScheduleSpec) (string, error) {
+	log.Debugf("Scheduling \"%s\" at \"%s\"\n", spec.Job.cmdLine(), spec.Cron)
+
+	expr, err := parseCron(spec.Cron)
+	if err != nil {
+		return "", err
+	}
+
+	loc, err := time.LoadLocation(spec.TimeZone)
+	if err != nil {
+		return "", fmt.Errorf("invalid time zone \"%s\": %v", spec.TimeZone, err)
+	}
+
+	if err := spec.Job.validate(); err != nil {
+		return "", err
+	}
+
+	copied := *spec
+	copied.Job = *spec.Job.clone()
+	id := generateRandomId()
+	sc := &schedule{
+		id:   id,
+		spec: &copied,
+		expr: expr,
+		loc:  loc,
+		s:    s,
+		m:    logsync.NewMutex(fmt.Sprintf("schedule %s", id)),
+	}
+
+	s.m.WLock("Schedule")
+	s.schedules[id] = sc
+	s.m.WUnlock("Schedule")
+
+	sc.m.WLock("Schedule")
+	sc.arm(s.clock.Now())
+	sc.m.WUnlock("Schedule")
+
+	return id, nil
+}
+
+// Unschedule removes a schedule, so that it doesn't start new jobs (the running ones are not stopped).
+func (s *Scheduler) Unschedule(id string) error {
+	s.m.WLock("Unschedule")
+	sc, ok := s.schedules[id]
+	delete(s.schedules, id)
+	s.m.WUnlock("Unschedule")
+
+	if !ok {
+		return &errors.NotFoundError{Id: id}
+	}
+
+	sc.m.WLock("Unschedule")
+	defer sc.m.WUnlock("Unschedule")
+
+	sc.removed = true
+	sc.disarm()
+
+	return nil
+}
+
+// PauseSchedule stops a schedule from starting new jobs, until it's resumed.
+func (s *Scheduler) PauseSchedule(id string) error {
+	sc, err := s.schedule(id)
+	if err != nil {
+		return err
+	}
+
+	sc.m.WLock("PauseSchedule")
+	defer sc.m.WUnlock("PauseSchedule")
+
+	sc.paused = true
+	sc.disarm()
+
+	return nil
+}
+
+// ResumeSchedule resumes a paused schedule. The runs missed while paused are skipped.
+func (s *Scheduler) ResumeSchedule(id string) error {
+	sc, err := s.schedule(id)
+	if err != nil {
+		return err
+	}
+
+	sc.m.WLock("ResumeSchedule")
+	defer sc.m.WUnlock("ResumeSchedule")
+
+	if sc.paused {
+		sc.paused = false
+		sc.arm(s.clock.Now())
+	}
+
+	return nil
+}
+
+// ScheduleStatus returns the status of a schedule, or an error if it doesn't exist.
+func (s *Scheduler) ScheduleStatus(id string) (*ScheduleStatus, error) {
+	sc, err := s.schedule(id)
+	if err != nil {
+		return nil, err
+	}
+
+	sc.m.RLock("ScheduleStatus")
+	defer sc.m.RUnlock("ScheduleStatus")
+
+	return &ScheduleStatus{
+		Cron:    sc.spec.Cron,
+		Overlap: sc.spec.Overlap,
+		Paused:  sc.paused,
+		Next:    sc.next,
+		Runs:    append([]string{}, sc.runs...),
+		Skipped: sc.skipped,
+	}, nil
+}
+
+func (s *Scheduler) schedule(id string) (*schedule, error) {
+	s.m.RLock("schedule")
+	defer s.m.RUnlock("schedule")
+
+	sc, ok := s.schedules[id]
+	if !ok {
+		return nil, &errors.NotFoundError{Id: id}
+	}
+
+	return sc, nil
+}
+
+// arm sets the timer for the next run after t (the lock has to be held)
+func (sc *schedule) arm(t time.Time) {
+	sc.next = sc.expr.next(t.In(sc.loc))
+	if sc.next.IsZero() {
+		log.Debugf("Schedule %s never runs again\n", sc.id)
+		return
+	}
+
+	next := sc.next
+	sc.timer = sc.s.clock.AfterFunc(next.Sub(sc.s.clock.Now()), func() {
+		sc.fire(next)
+	})
+}
+
+// disarm stops the timer of the next run (the lock has to be held)
+func (sc *schedule) disarm() {
+	if sc.timer != nil {
+		sc.timer.Stop()
+		sc.timer = nil
+	}
+	sc.next = time.Time{}
+}
+
+// fire starts a new run (according to the overlap policy), and sets the timer for the next one
+func (sc *schedule) fire(at time.Time) {
+	sc.m.WLock("fire")
+	defer sc.m.WUnlock("fire")
+
+	if sc.paused || sc.removed || !sc.next.Equal(at) {
+		// The schedule has changed since the timer was set
+		return
+	}
+
+	// The next run is computed from the expected time of this one, so that the schedule doesn't drift
+	sc.arm(at)
+
+	if len(sc.runs) > 0 && sc.spec.Overlap != OverlapAllow {
+		last := sc.runs[len(sc.runs)-1]
+
+		if sc.s.isActive(last) {
+			if sc.spec.Overlap == OverlapSkip {
+				log.Debugf("Skipping run of schedule %s, job %s is still running\n", sc.id, last)
+				sc.skipped++
+				return
+			}
+
+			log.Debugf("Replacing job %s of schedule %s\n", last, sc.id)
+			if _, err := sc.s.Cancel(last); err != nil {
+				_, _ = sc.s.Stop(last)
+			}
+		}
+	}
+
+	job := sc.spec.Job
+	job.Labels = map[string]string{}
+	for key, value := range sc.spec.Job.Labels {
+		job.Labels[key] = value
+	}
+	job.Labels[ScheduleLabel] = sc.id
+
+	id, err := sc.s.StartJob(&job)
+	if err != nil {
+		log.Errorf("Cannot start job of schedule %s: %v\n", sc.id, err)
+	}
+
+	// A job that can't be started is still a run, as errored
+	if id != "" {
+		sc.runs = append(sc.runs, id)
+	}
+}
+
+// isActive checks if a job is not over yet (running, or waiting to run)
+func (s *Scheduler) isActive(id string) bool {
+	st, err := s.Status(id)
+	if err != nil {
+		return false
+	}
+
+	return !st.Type.isFinal()
+}
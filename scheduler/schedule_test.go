@@ -0,0 +1,144 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+var scheduleStart = time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestSchedulerSchedule(t *testing.T) {
+	s, c := newScheduledScheduler()
+
+	id, err := s.Schedule(&ScheduleSpec{
+		Cron: "*/10 * * * * *",
+		Job:  JobSpec{Executable: "sleep", Args: []string{"0"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertScheduleNext(t, s, id, scheduleStart.Add(10*time.Second))
+
+	c.Advance(25 * time.Second)
+	s.Wait()
+
+	st := assertScheduleRuns(t, s, id, 2)
+	assertScheduleNext(t, s, id, scheduleStart.Add(30*time.Second))
+
+	// Every run is a normal job, linked to the schedule
+	for _, run := range st.Runs {
+		assertSchedulerStatus(t, s, run, Exited, 0)
+		if s.jobs[run].spec.Labels[ScheduleLabel] != id {
+			t.Fatalf("Job %s should be labelled with schedule %s", run, id)
+		}
+	}
+}
+
+func TestSchedulerScheduleOverlapSkip(t *testing.T) {
+	s, c := newScheduledScheduler()
+
+	id, _ := s.Schedule(&ScheduleSpec{
+		Cron:    "* * * * * *",
+		Overlap: OverlapSkip,
+		Job:     JobSpec{Executable: "sleep", Args: []string{"0.2"}},
+	})
+
+	c.Advance(2 * time.Second)
+
+	st := assertScheduleRuns(t, s, id, 1)
+	if st.Skipped != 1 {
+		t.Fatalf("Expected 1 skipped run, got %d", st.Skipped)
+	}
+
+	s.Wait()
+}
+
+func TestSchedulerScheduleOverlapReplace(t *testing.T) {
+	s, c := newScheduledScheduler()
+
+	id, _ := s.Schedule(&ScheduleSpec{
+		Cron:    "* * * * * *",
+		Overlap: OverlapReplace,
+		Job:     JobSpec{Executable: "sleep", Args: []string{"0.2"}},
+	})
+
+	c.Advance(2 * time.Second)
+
+	st := assertScheduleRuns(t, s, id, 2)
+	assertSchedulerStatus(t, s, st.Runs[0], Killed, -1)
+	assertSchedulerStatus(t, s, st.Runs[1], Running, -1)
+
+	s.Wait()
+}
+
+func TestSchedulerPauseSchedule(t *testing.T) {
+	s, c := newScheduledScheduler()
+
+	id, _ := s.Schedule(&ScheduleSpec{
+		Cron: "*/10 * * * * *",
+		Job:  JobSpec{Executable: "sleep", Args: []string{"0"}},
+	})
+
+	_ = s.PauseSchedule(id)
+	assertScheduleNext(t, s, id, time.Time{})
+
+	c.Advance(15 * time.Second)
+	assertScheduleRuns(t, s, id, 0)
+
+	_ = s.ResumeSchedule(id)
+	assertScheduleNext(t, s, id, scheduleStart.Add(20*time.Second))
+
+	c.Advance(10 * time.Second)
+	s.Wait()
+	assertScheduleRuns(t, s, id, 1)
+
+	_ = s.Unschedule(id)
+	if _, err := s.ScheduleStatus(id); err == nil {
+		t.Fatalf("Schedule should be removed")
+	}
+}
+
+func TestSchedulerScheduleInvalid(t *testing.T) {
+	s, _ := newScheduledScheduler()
+
+	for _, spec := range []*ScheduleSpec{
+		{Cron: "* * *", Job: JobSpec{Executable: "sleep"}},
+		{Cron: "* * * * * *", TimeZone: "Nowhere/Unknown", Job: JobSpec{Executable: "sleep"}},
+		{Cron: "* * * * * *"},
+	} {
+		if _, err := s.Schedule(spec); err == nil {
+			t.Fatalf("Schedule %+v should be invalid", spec)
+		}
+	}
+}
+
+func newScheduledScheduler() (*Scheduler, *fakeClock) {
+	c := newFakeClock(scheduleStart)
+
+	s := New(QueueRunner)
+	s.clock = c
+
+	return s, c
+}
+
+func assertScheduleRuns(t *testing.T, s *Scheduler, id string, expected int) *ScheduleStatus {
+	st, err := s.ScheduleStatus(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(st.Runs) != expected {
+		t.Fatalf("Expected %d runs, got %d", expected, len(st.Runs))
+	}
+
+	return st
+}
+
+func assertScheduleNext(t *testing.T, s *Scheduler, id string, expected time.Time) {
+	st, _ := s.ScheduleStatus(id)
+
+	if !st.Next.Equal(expected) {
+		t.Fatalf("Expected next run at %s, got %s", expected, st.Next)
+	}
+}
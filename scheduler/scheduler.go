@@ -1,13 +1,16 @@
 package scheduler
 
 import (
+	"context"
 	"fmt"
 	"github.com/beoboo/job-scheduler/library/errors"
-	"github.com/beoboo/job-scheduler/library/helpers"
 	"github.com/beoboo/job-scheduler/library/log"
 	"github.com/beoboo/job-scheduler/library/logsync"
 	"github.com/beoboo/job-scheduler/library/stream"
+	"io"
 	"os"
+	"path/filepath"
+	"time"
 )
 
 const (
@@ -15,10 +18,33 @@ const (
 )
 
 type Scheduler struct {
-	runner string
-	jobs   map[string]*job
-	m      logsync.Mutex
-	wg     logsync.WaitGroup
+	runner          string
+	jobs            map[string]*job
+	subscribers     map[int]func(j *job)
+	nextSubscriber  int
+	streamOpts      []stream.Option
+	outputDir       string
+	outputMaxSize   int64
+	outputMaxFiles  int
+	maxRunning      int
+	maxPerLabel     map[string]int
+	running         int
+	runningJobs     map[string]*job
+	runningByLabel  map[string]int
+	queue           []*job
+	preemption      bool
+	preemptionGrace time.Duration
+	preempting      int
+	allocatable     Resources
+	allocated       Resources
+	admission       Admission
+	schedules       map[string]*schedule
+	delayed         map[string]timer
+	workflows       map[string]*workflow
+	groups          map[string]*group
+	clock           clock
+	m               logsync.Mutex
+	wg              logsync.WaitGroup
 }
 
 func isRoot() bool {
@@ -26,28 +52,59 @@ func isRoot() bool {
 }
 
 // New creates a scheduler.
-func New(runner string) *Scheduler {
+func New(runner string, opts ...Option) *Scheduler {
 	if !isRoot() {
 		log.Fatalln("Please run this with root privileges.")
 	}
 
-	return &Scheduler{
-		runner: runner,
-		jobs:   make(map[string]*job),
-		m:      logsync.NewMutex("Scheduler"),
-		wg:     logsync.NewWaitGroup("Scheduler"),
+	s := &Scheduler{
+		runner:         runner,
+		jobs:           make(map[string]*job),
+		subscribers:    make(map[int]func(j *job)),
+		maxPerLabel:    make(map[string]int),
+		runningByLabel: make(map[string]int),
+		runningJobs:    make(map[string]*job),
+		schedules:      make(map[string]*schedule),
+		delayed:        make(map[string]timer),
+		workflows:      make(map[string]*workflow),
+		groups:         make(map[string]*group),
+		clock:          realClock{},
+		m:              logsync.NewMutex("Scheduler"),
+		wg:             logsync.NewWaitGroup("Scheduler"),
 	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
 }
 
 // NewSelf creates a scheduler for "/proc/self/exe".
-func NewSelf() *Scheduler {
-	return New(Self)
+func NewSelf(opts ...Option) *Scheduler {
+	return New(Self, opts...)
 }
 
 // Start runs a new job.
 func (s *Scheduler) Start(executable string, mem int, args ...string) (string, error) {
-	log.Debugf("Starting executable: \"%s\"\n", helpers.FormatCmdLine(executable, args...))
-	j := newJob(&s.wg)
+	return s.StartJob(&JobSpec{
+		Executable: executable,
+		Args:       args,
+		Memory:     mem,
+	})
+}
+
+// StartJob runs a new job described by a spec.
+// If the job is added but its process can't be started, it's errored, and its ID is returned with the error.
+func (s *Scheduler) StartJob(spec *JobSpec) (string, error) {
+	log.Debugf("Starting executable: \"%s\"\n", spec.cmdLine())
+
+	if err := spec.validate(); err != nil {
+		return "", err
+	}
+
+	// The spec is copied, so that the caller can't change it while the job is running
+	spec = spec.clone()
 
 	// If the executable is not the same as the predefined runner, the process has to be isolated
 	/**
@@ -56,38 +113,67 @@ func (s *Scheduler) Start(executable string, mem int, args ...string) (string, e
 	    It works well with "/proc/self/exe", less for a "worker" or "child" binary that needs to be
 	    under $PATH.
 	*/
-	if s.runner != executable {
+	if s.runner != spec.Executable {
 		log.Debugln("Starting in isolated mode")
-		args = append([]string{
-			"child", // Main subcommand
-			"--mem", itoa(mem),
-			j.id,       // The job ID
-			executable, // The original executable
-		}, args...)
-
-		err := j.startIsolated(s.runner, mem, args...)
-		if err != nil {
+
+		j := newJobFromSpec(spec, &s.wg, s.streamOpts...)
+		j.clock = s.clock
+		j.onFinished = func() {
+			s.release(j)
+		}
+
+		// The job is added under the same lock that reserves its slot (or queues it), so that it's never running
+		// without being known to the scheduler
+		s.m.WLock("Start")
+
+		at := spec.startTime(s.clock.Now())
+		if at.IsZero() {
+			// The job is admitted before creating its log file, so that a rejected job leaves nothing behind
+			if err := s.admit(j); err != nil {
+				s.m.WUnlock("Start")
+				return "", err
+			}
+		}
+
+		if err := s.persistOutput(j); err != nil {
+			s.m.WUnlock("Start")
 			return "", err
 		}
 
-		log.Debugf("Job ID: %s\n", j.id)
-		log.Debugf("Status: %s\n", j.status())
+		s.add(j)
 
-		s.m.WLock("Start")
-		defer s.m.WUnlock("Start")
+		if !at.IsZero() {
+			s.delay(j, at)
+			s.m.WUnlock("Start")
 
-		s.jobs[j.id] = j
+			log.Debugf("Job ID: %s (scheduled at %s)\n", j.id, at)
+			return j.id, nil
+		}
+
+		reserved := s.place(j)
+		s.m.WUnlock("Start")
+
+		if reserved {
+			// A job that can't be started is still known, as errored, so its ID is returned with the error
+			if err := s.launch(j); err != nil {
+				return j.id, err
+			}
+		}
 
 		return j.id, nil
 	}
 
+	j := newJobFromSpec(spec, &s.wg)
+	args := spec.Args
+
 	jobId := args[0]
-	executable = args[1]
+	executable := args[1]
 	args = args[2:]
 
 	log.Debugln("Starting in standard mode")
 
-	ec, err := j.startChild(jobId, executable, mem, args...)
+	// The environment and the working directory of the job are the ones inherited from the parent
+	ec, err := j.startChild(jobId, executable, spec.Memory, args...)
 	if err != nil {
 		log.Errorln(err)
 	}
@@ -97,6 +183,92 @@ func (s *Scheduler) Start(executable string, mem int, args ...string) (string, e
 	return "", nil
 }
 
+// submit starts a job, or queues it if it can't run yet. It returns an error if the job is not admitted, or it
+// can't be started.
+func (s *Scheduler) submit(j *job) error {
+	s.m.WLock("submit")
+	if err := s.admit(j); err != nil {
+		s.m.WUnlock("submit")
+		return err
+	}
+
+	reserved := s.place(j)
+	s.m.WUnlock("submit")
+
+	if !reserved {
+		return nil
+	}
+
+	return s.launch(j)
+}
+
+// place reserves a running slot for an admitted job, or queues it (possibly preempting a running one) if there's
+// none available. It returns if the slot has been reserved, so that the job can be launched (the lock has to be
+// held).
+func (s *Scheduler) place(j *job) bool {
+	if s.reserve(j) {
+		return true
+	}
+
+	s.enqueue(j)
+	s.preemptFor(j)
+
+	log.Debugf("Job ID: %s (queued)\n", j.id)
+	return false
+}
+
+// launch starts a job through the runner, that runs it isolated in a child process
+func (s *Scheduler) launch(j *job) error {
+	options := []string{
+		"child", // Main subcommand
+		"--mem", itoa(j.spec.Memory),
+	}
+	if j.spec.Tty {
+		options = append(options, "--tty")
+	}
+
+	args := append(append(options,
+		j.id,              // The job ID
+		j.spec.Executable, // The original executable
+	), j.spec.Args...)
+
+	j.runner = true
+	if err := j.startIsolated(s.runner, j.spec.Memory, args...); err != nil {
+		return err
+	}
+
+	s.checkHealth(j)
+
+	log.Debugf("Job ID: %s\n", j.id)
+	log.Debugf("Status: %s\n", j.status())
+
+	return nil
+}
+
+// add stores a new job, notifying the subscribers (the lock has to be held)
+func (s *Scheduler) add(j *job) {
+	s.jobs[j.id] = j
+
+	for _, notify := range s.subscribers {
+		notify(j)
+	}
+}
+
+// subscribe calls notify for every job added from now on (the lock has to be held).
+// It returns the function to unsubscribe.
+func (s *Scheduler) subscribe(notify func(j *job)) func() {
+	id := s.nextSubscriber
+	s.nextSubscriber += 1
+	s.subscribers[id] = notify
+
+	return func() {
+		s.m.WLock("unsubscribe")
+		defer s.m.WUnlock("unsubscribe")
+
+		delete(s.subscribers, id)
+	}
+}
+
 // Stop stops a running job, or an error if the job.job doesn't exist.
 func (s *Scheduler) Stop(id string) (*JobStatus, error) {
 	log.Debugf("Stopping job %s\n", id)
@@ -134,6 +306,7 @@ func (s *Scheduler) Status(id string) (*JobStatus, error) {
 }
 
 // Output returns the stream of the stdout/stderr of a job, or an error if the job.job doesn't exist.
+// When the output is persisted, the one of a job run before a restart is read from its log file.
 func (s *Scheduler) Output(id string) (*stream.Stream, error) {
 	//log.Debugf("Streaming output for job \"%s\"\n", id)
 
@@ -142,12 +315,166 @@ func (s *Scheduler) Output(id string) (*stream.Stream, error) {
 	j, ok := s.jobs[id]
 
 	if !ok {
-		return nil, &errors.NotFoundError{Id: id}
+		return s.openOutput(id)
 	}
 
 	return j.output(), nil
 }
 
+// Stdout returns the stdout of a job as a plain byte stream, or an error if the job doesn't exist.
+// The output of a job running in a pseudo-terminal is considered its stdout.
+func (s *Scheduler) Stdout(id string) (io.ReadCloser, error) {
+	o, err := s.Output(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return stream.NewReader(o, stream.OfType(stream.Output, stream.Tty)), nil
+}
+
+// Stderr returns the stderr of a job as a plain byte stream, or an error if the job doesn't exist.
+func (s *Scheduler) Stderr(id string) (io.ReadCloser, error) {
+	o, err := s.Output(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return stream.NewReader(o, stream.OfType(stream.Error)), nil
+}
+
+// Search returns the lines of the output of a job matching pattern, or an error if the job doesn't exist or the
+// pattern is not valid (see stream.Stream.Search).
+func (s *Scheduler) Search(ctx context.Context, id, pattern string, opts ...stream.SearchOption) (<-chan *stream.Match, error) {
+	log.Debugf("Searching \"%s\" in output for job \"%s\"\n", pattern, id)
+
+	o, err := s.Output(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return o.Search(ctx, pattern, opts...)
+}
+
+// persistOutput writes the output of a job to a log file, if an output folder is configured
+func (s *Scheduler) persistOutput(j *job) error {
+	if s.outputDir == "" {
+		return nil
+	}
+
+	lf, err := stream.OpenLogFile(s.outputPath(j.id), s.outputMaxSize, s.outputMaxFiles)
+	if err != nil {
+		return fmt.Errorf("cannot create output log file: %v", err)
+	}
+
+	// The job is not running yet, so its stream can be safely replaced
+	j.outputSt = stream.New(append(s.streamOpts, stream.WithLogFile(lf))...)
+
+	return nil
+}
+
+// openOutput reads the output of a job from its log file
+func (s *Scheduler) openOutput(id string) (*stream.Stream, error) {
+	// The ID must not be used to access files outside the output folder
+	if s.outputDir == "" || id == "" || filepath.Base(id) != id {
+		return nil, &errors.NotFoundError{Id: id}
+	}
+
+	o, err := stream.Open(s.outputPath(id))
+	if os.IsNotExist(err) {
+		return nil, &errors.NotFoundError{Id: id}
+	}
+
+	return o, err
+}
+
+func (s *Scheduler) outputPath(id string) string {
+	return filepath.Join(s.outputDir, id+".log")
+}
+
+// Input returns the stdin of a job, or an error if the job doesn't exist or was not started with OpenStdin.
+// Closing it sends an EOF to the job.
+func (s *Scheduler) Input(id string) (io.WriteCloser, error) {
+	s.m.RLock("Input")
+	defer s.m.RUnlock("Input")
+	j, ok := s.jobs[id]
+
+	if !ok {
+		return nil, &errors.NotFoundError{Id: id}
+	}
+
+	return j.input()
+}
+
+// Attach returns the pseudo-terminal of a job, or an error if the job doesn't exist or was not started with Tty.
+func (s *Scheduler) Attach(id string) (*Terminal, error) {
+	s.m.RLock("Attach")
+	defer s.m.RUnlock("Attach")
+	j, ok := s.jobs[id]
+
+	if !ok {
+		return nil, &errors.NotFoundError{Id: id}
+	}
+
+	return j.terminal()
+}
+
+// Stats returns the current resource usage of a job, or an error if the job doesn't exist.
+func (s *Scheduler) Stats(id string) (*JobStats, error) {
+	s.m.RLock("Stats")
+	defer s.m.RUnlock("Stats")
+	j, ok := s.jobs[id]
+
+	if !ok {
+		return nil, &errors.NotFoundError{Id: id}
+	}
+
+	return j.stats(), nil
+}
+
+// WatchStats samples the resource usage of a job at every interval, or returns an error if the job doesn't exist.
+// The channel is closed after sending the stats of the finished job, or when the context is cancelled.
+func (s *Scheduler) WatchStats(ctx context.Context, id string, interval time.Duration) (<-chan *JobStats, error) {
+	s.m.RLock("WatchStats")
+	j, ok := s.jobs[id]
+	s.m.RUnlock("WatchStats")
+
+	if !ok {
+		return nil, &errors.NotFoundError{Id: id}
+	}
+
+	next := make(chan *JobStats)
+
+	go func() {
+		defer close(next)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			// The status is checked before collecting the stats, so that the last ones are the final ones
+			finished := j.status().Type != Running
+
+			select {
+			case next <- j.stats():
+			case <-ctx.Done():
+				return
+			}
+
+			if finished {
+				return
+			}
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return next, nil
+}
+
 // Size returns the number of stored jobs.
 func (s *Scheduler) Size() int {
 	s.m.RLock("Size")
@@ -1,8 +1,15 @@
 package scheduler
 
 import (
+	"context"
+	"flag"
 	"github.com/beoboo/job-scheduler/library/log"
+	"github.com/beoboo/job-scheduler/library/stream"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strings"
+	"syscall"
 	"testing"
 	"time"
 )
@@ -13,6 +20,30 @@ func init() {
 	log.SetLevel(log.Debug)
 }
 
+// TestMain runs the test binary as the runner of the jobs started by a scheduler created with NewSelf (like the
+// "child" command of the main app), so that they're run in isolation like in production
+func TestMain(m *testing.M) {
+	if len(os.Args) > 1 && os.Args[1] == "child" {
+		// Anything logged to stdout would end up in the output of the job
+		log.SetLevel(log.Warn)
+
+		fs := flag.NewFlagSet("child", flag.ExitOnError)
+		mem := fs.Int("mem", 0, "Max memory usage in MB")
+		tty := fs.Bool("tty", false, "Run in a pseudo-terminal")
+		_ = fs.Parse(os.Args[2:])
+
+		_, err := NewSelf().StartJob(&JobSpec{
+			Executable: os.Args[0],
+			Args:       fs.Args(),
+			Memory:     *mem,
+			Tty:        *tty,
+		})
+		log.Fatalln(err)
+	}
+
+	os.Exit(m.Run())
+}
+
 var s = New(Runner)
 
 func TestSchedulerStart(t *testing.T) {
@@ -65,6 +96,353 @@ func TestSchedulerOutput(t *testing.T) {
 	assertSchedulerOutput(t, s, id, expected)
 }
 
+func TestSchedulerWatchStats(t *testing.T) {
+	id, _ := s.Start("sleep", 0, "0.1")
+
+	stats, err := s.WatchStats(context.Background(), id, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var last *JobStats
+	for st := range stats {
+		last = st
+	}
+
+	if last == nil {
+		t.Fatalf("Stats should have been collected")
+	}
+
+	assertSchedulerStatus(t, s, id, Exited, 0)
+}
+
+func TestSchedulerStatsNotFound(t *testing.T) {
+	_, err := s.Stats("unknown")
+	if err == nil {
+		t.Fatalf("Stats should fail for an unknown job")
+	}
+}
+
+func TestSchedulerStartJobEnvNotInArgs(t *testing.T) {
+	id, err := s.StartJob(&JobSpec{
+		Executable: "env",
+		Env:        []string{"SECRET=value"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	o, _ := s.Output(id)
+	for l := range o.Read() {
+		if strings.Contains(string(l.Text), "SECRET") {
+			t.Fatalf("Job environment should not be passed as arguments, got \"%s\"", l.Text)
+		}
+	}
+}
+
+func TestSchedulerStartJobLargeEnv(t *testing.T) {
+	s := NewSelf()
+
+	// The environment of the job is larger than the max size of a single variable of the runner
+	value := strings.Repeat("x", 64*1024)
+	id, err := s.StartJob(&JobSpec{
+		Executable: "sh",
+		Args:       []string{"-c", "echo ${#BIG1} ${#BIG4}"},
+		Env:        []string{"BIG1=" + value, "BIG2=" + value, "BIG3=" + value, "BIG4=" + value},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.Wait()
+
+	assertSchedulerStatus(t, s, id, Exited, 0)
+	assertSchedulerOutput(t, s, id, []string{"65536 65536\n"})
+}
+
+func TestSchedulerStartJobEnv(t *testing.T) {
+	s := NewSelf()
+
+	// The executable is still found without a PATH in the environment of the job
+	id, err := s.StartJob(&JobSpec{
+		Executable: "env",
+		Env:        []string{"FOO=bar"},
+		CleanEnv:   true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.Wait()
+
+	assertSchedulerStatus(t, s, id, Exited, 0)
+	assertSchedulerOutput(t, s, id, []string{"FOO=bar\n"})
+}
+
+func TestSchedulerStartJobInheritEnv(t *testing.T) {
+	_ = os.Setenv("JOB_TEST_INHERITED", "inherited")
+	_ = os.Setenv("JOB_TEST_SECRET", "secret")
+	defer os.Unsetenv("JOB_TEST_INHERITED")
+	defer os.Unsetenv("JOB_TEST_SECRET")
+
+	s := NewSelf()
+
+	id, _ := s.StartJob(&JobSpec{
+		Executable: "env",
+		CleanEnv:   true,
+		InheritEnv: []string{"JOB_TEST_INHERITED"},
+	})
+
+	s.Wait()
+
+	assertSchedulerOutput(t, s, id, []string{"JOB_TEST_INHERITED=inherited\n"})
+}
+
+func TestSchedulerStartJobDir(t *testing.T) {
+	dir := t.TempDir()
+	s := NewSelf()
+
+	id, _ := s.StartJob(&JobSpec{Executable: "pwd", Dir: dir})
+
+	s.Wait()
+
+	assertSchedulerOutput(t, s, id, []string{dir + "\n"})
+
+	// A runner with a relative path is still found
+	id, err := New(Runner).StartJob(&JobSpec{Executable: "pwd", Dir: dir})
+	if err != nil {
+		t.Fatalf("Job should start with a relative runner, got %v", err)
+	}
+}
+
+func TestSchedulerStartJobSignal(t *testing.T) {
+	s := NewSelf()
+
+	// The job is terminated by a signal inside the PID namespace of the runner
+	id, _ := s.StartJob(&JobSpec{Executable: "sh", Args: []string{"-c", "kill -SEGV $$"}})
+
+	s.Wait()
+
+	st, _ := s.Status(id)
+	assertStatus(t, st, Errored, -1)
+	if st.Signal != syscall.SIGSEGV {
+		t.Fatalf("Job should be terminated by %s, got %s", syscall.SIGSEGV, st.Signal)
+	}
+}
+
+func TestSchedulerStartJobInvalidEnv(t *testing.T) {
+	_, err := s.StartJob(&JobSpec{
+		Executable: "env",
+		Env:        []string{"INVALID"},
+	})
+	if err == nil {
+		t.Fatalf("Job should not start with an invalid environment")
+	}
+}
+
+func TestSchedulerStartJobLaunchError(t *testing.T) {
+	s := New("../bin/unknown.sh")
+
+	id, err := s.StartJob(&JobSpec{Executable: "true"})
+	if err == nil {
+		t.Fatalf("Job should not start without a runner")
+	}
+
+	// The job is still known, as errored
+	st, err := s.Status(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if st.Type != Errored {
+		t.Fatalf("Expected status %s, got %s", Errored, st.Type)
+	}
+}
+
+func TestSchedulerStartJobCopiesSpec(t *testing.T) {
+	s := New(QueueRunner)
+
+	spec := &JobSpec{
+		Executable: "sleep",
+		Args:       []string{"10"},
+		Env:        []string{"FOO=bar"},
+		Retry:      RetryPolicy{ExitCodes: []int{1}},
+		Health:     &HealthCheck{Exec: []string{"true"}, Interval: time.Minute},
+	}
+	id, _ := s.StartJob(spec)
+
+	// Changing the spec of the caller doesn't change the one of the job
+	spec.Args[0] = "0"
+	spec.Env[0] = "FOO=baz"
+	spec.Retry.ExitCodes[0] = 2
+	spec.Health.Exec[0] = "false"
+	spec.Health.Interval = time.Second
+
+	copied := s.jobs[id].spec
+	if copied.Args[0] != "10" || copied.Env[0] != "FOO=bar" || copied.Retry.ExitCodes[0] != 1 {
+		t.Fatalf("Job spec should not change, got %+v", copied)
+	}
+	if copied.Health.Exec[0] != "true" || copied.Health.Interval != time.Minute {
+		t.Fatalf("Job health check should not change, got %+v", copied.Health)
+	}
+
+	_, _ = s.Stop(id)
+	s.Wait()
+}
+
+func TestSchedulerAttach(t *testing.T) {
+	id, err := s.StartJob(&JobSpec{
+		Executable: "sh",
+		Tty:        true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	term, err := s.Attach(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The runner only echoes its arguments
+	line := <-term.Read()
+	if line.Type != stream.Tty {
+		t.Fatalf("Terminal output should be \"%s\", got \"%s\"", stream.Tty, line.Type)
+	}
+	if !strings.Contains(string(line.Text), "--tty") {
+		t.Fatalf("Runner should be started with a terminal, got \"%s\"", line.Text)
+	}
+
+	s.Wait()
+}
+
+func TestSchedulerAttachWithoutTty(t *testing.T) {
+	id, _ := s.Start("sleep", 0, "0")
+
+	if _, err := s.Attach(id); err == nil {
+		t.Fatalf("Job should not have a terminal")
+	}
+
+	s.Wait()
+}
+
+func TestSchedulerOutputAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	s1 := New(Runner, WithOutputDir(dir, 0, 0))
+
+	id, err := s1.Start("sleep", 0, "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s1.Wait()
+
+	s2 := New(Runner, WithOutputDir(dir, 0, 0))
+
+	assertSchedulerOutput(t, s2, id, []string{Runner})
+
+	if _, err := s2.Output("../" + filepath.Base(dir) + "/" + id); err == nil {
+		t.Fatalf("Output should not be read outside of the output folder")
+	}
+}
+
+func TestSchedulerStdoutAndStderr(t *testing.T) {
+	id, _ := s.Start("sleep", 0, "0")
+
+	s.Wait()
+
+	stdout, err := s.Stdout(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdout.Close()
+
+	data, _ := ioutil.ReadAll(stdout)
+	if !strings.Contains(string(data), Runner) {
+		t.Fatalf("Stdout should contain \"%s\", got \"%s\"", Runner, data)
+	}
+
+	stderr, err := s.Stderr(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stderr.Close()
+
+	data, _ = ioutil.ReadAll(stderr)
+	if len(data) != 0 {
+		t.Fatalf("Stderr should be empty, got \"%s\"", data)
+	}
+}
+
+func TestSchedulerMergeOutput(t *testing.T) {
+	id1, _ := s.Start("sleep", 0, "0")
+	id2, _ := s.Start("sleep", 0, "0")
+
+	s.Wait()
+
+	lines, err := s.MergeOutput(context.Background(), id1, id2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The channel is closed once both outputs are closed
+	assertMergedJobs(t, lines, id1, id2)
+
+	if _, err := s.MergeOutput(context.Background(), id1, "unknown"); err == nil {
+		t.Fatalf("Merging output for unknown jobs should fail")
+	}
+}
+
+func TestSchedulerMergeOutputBySelector(t *testing.T) {
+	s := New(QueueRunner)
+	app := map[string]string{"app": "merged"}
+
+	id1, _ := s.StartJob(&JobSpec{Executable: "sh", Args: []string{"-c", "echo first; sleep 0.2"}, Labels: app})
+	_, _ = s.StartJob(&JobSpec{Executable: "echo", Args: []string{"other"}, Labels: map[string]string{"app": "other"}})
+
+	lines := s.MergeOutputBySelector(context.Background(), app)
+
+	// Jobs started after the merge are included too, while the merged ones are running
+	id2, _ := s.StartJob(&JobSpec{Executable: "echo", Args: []string{"second"}, Labels: app})
+
+	// The channel is closed once all the merged outputs are closed
+	assertMergedJobs(t, lines, id1, id2)
+
+	s.Wait()
+
+	// Without any job matching, the channel is only closed when ctx is done
+	ctx, cancel := context.WithCancel(context.Background())
+	lines = s.MergeOutputBySelector(ctx, map[string]string{"app": "none"})
+	cancel()
+
+	for range lines {
+	}
+}
+
+func TestSchedulerSearch(t *testing.T) {
+	id, _ := s.Start("sleep", 0, "0")
+
+	s.Wait()
+
+	matches, err := s.Search(context.Background(), id, "sleep")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := <-matches
+	if m == nil || !strings.Contains(string(m.Text), "sleep") {
+		t.Fatalf("Search should match \"sleep\", got %v", m)
+	}
+
+	if _, err := s.Search(context.Background(), "unknown", "sleep"); err == nil {
+		t.Fatalf("Searching the output of an unknown job should fail")
+	}
+
+	if _, err := s.Search(context.Background(), id, "(", stream.AsRegexp()); err == nil {
+		t.Fatalf("Searching an invalid regexp should fail")
+	}
+}
+
 func assertSchedulerStatus(t *testing.T, s *Scheduler, id string, expectedStatusType StatusType, expectedExitCode int) {
 	st, _ := s.Status(id)
 	assertStatus(t, st, expectedStatusType, expectedExitCode)
@@ -83,3 +461,16 @@ func assertSchedulerOutput(t *testing.T, s *Scheduler, id string, expected []str
 		}
 	}
 }
+
+func assertMergedJobs(t *testing.T, lines <-chan *JobLine, expected ...string) {
+	received := map[string]bool{}
+	for l := range lines {
+		received[l.JobId] = true
+	}
+
+	for _, id := range expected {
+		if !received[id] {
+			t.Fatalf("Merged output should contain lines from job \"%s\"", id)
+		}
+	}
+}
@@ -0,0 +1,290 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/beoboo/job-scheduler/library/helpers"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// JobSpec describes a job to be run by the Scheduler
+type JobSpec struct {
+	Executable string
+	Args       []string
+	// Memory is the max memory usage in bytes (0 means no limit)
+	Memory int
+	// Requests are the resources reserved for the job when it runs (see WithCapacity)
+	Requests Resources
+	// Env holds the environment variables of the job, as "KEY=VALUE" pairs.
+	// They're added to the inherited ones, overriding them if they have the same key.
+	Env []string
+	// CleanEnv starts the job from an empty environment, instead of the one of the scheduler.
+	// Note that without a PATH, the executable needs to be an absolute path.
+	CleanEnv bool
+	// InheritEnv lists the variables of the scheduler environment that are kept when CleanEnv is set
+	InheritEnv []string
+	// Dir is the working directory of the job (if empty, the one of the scheduler is used)
+	Dir string
+	// The initial input of the job can be provided as a payload, a file path, or a reader (only one of them, and not
+	// a reader if the job is retried or restarted, since every run reads the input again).
+	// Without any of them (and OpenStdin), the job reads from /dev/null.
+	StdinData   []byte
+	StdinFile   string
+	StdinReader io.Reader
+	// OpenStdin keeps the stdin of the job open after the initial input, so that it can be streamed
+	// through Scheduler.Input (until it's closed)
+	OpenStdin bool
+	// Tty runs the job in a pseudo-terminal, that can be used through Scheduler.Attach (it implies OpenStdin)
+	Tty bool
+	// Framing sets how the output is split into lines (FramingRaw by default).
+	// With FramingLines, lines longer than MaxLineLength (MAX_LINE_LENGTH if 0) are split, and a partial line
+	// without a trailing newline is emitted after FlushTimeout (if set) or when the output is closed.
+	Framing       Framing
+	MaxLineLength int
+	FlushTimeout  time.Duration
+	// Secrets are the values (i.e. credentials passed in Env) masked in the output of the job, together with the
+	// ones matching SecretPatterns (regexps matched within a line)
+	Secrets        []string
+	SecretPatterns []string
+	// StartAt or StartAfter (only one of them) delay the start of the job, that's Scheduled until then
+	StartAt    time.Time
+	StartAfter time.Duration
+	// Retry sets if and how the job is retried when it fails
+	Retry RetryPolicy
+	// Restart sets if and how the job is restarted once it's over, to keep it up
+	Restart RestartPolicy
+	// Health checks if the running job is working
+	Health *HealthCheck
+	// Priority sets the order of the queued jobs (the higher first), and which running jobs can be preempted
+	// to start a new one (see WithPreemption)
+	Priority int
+	// Labels are used to select jobs (i.e. to merge their output)
+	Labels map[string]string
+}
+
+// clone returns a deep copy of the spec (apart from StdinReader, that's consumed by the job)
+func (s *JobSpec) clone() *JobSpec {
+	c := *s
+	c.Args = copyStrings(s.Args)
+	c.Env = copyStrings(s.Env)
+	c.InheritEnv = copyStrings(s.InheritEnv)
+	c.Secrets = copyStrings(s.Secrets)
+	c.SecretPatterns = copyStrings(s.SecretPatterns)
+	c.Retry.Statuses = append([]StatusType(nil), s.Retry.Statuses...)
+	c.Retry.ExitCodes = append([]int(nil), s.Retry.ExitCodes...)
+
+	// A payload is an input source even if it's empty, so it's only nil if it was
+	if s.StdinData != nil {
+		c.StdinData = append([]byte{}, s.StdinData...)
+	}
+
+	if s.Health != nil {
+		health := *s.Health
+		health.Exec = copyStrings(s.Health.Exec)
+		c.Health = &health
+	}
+
+	c.Labels = make(map[string]string, len(s.Labels))
+	for key, value := range s.Labels {
+		c.Labels[key] = value
+	}
+
+	return &c
+}
+
+// validate checks that the spec can be used to start a job
+func (s *JobSpec) validate() error {
+	if s.Executable == "" {
+		return fmt.Errorf("missing executable")
+	}
+
+	sources := 0
+	for _, set := range []bool{s.StdinData != nil, s.StdinFile != "", s.StdinReader != nil} {
+		if set {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return fmt.Errorf("only one of stdin data, file or reader can be provided")
+	}
+
+	// A reader is drained by the first run, so it can't be read again
+	if s.StdinReader != nil && (s.Retry.MaxAttempts > 1 || s.Restart.Mode != RestartNever) {
+		return fmt.Errorf("stdin reader cannot be used with retries or restarts")
+	}
+
+	for _, kv := range s.Env {
+		if strings.Index(kv, "=") <= 0 {
+			return fmt.Errorf("invalid environment variable \"%s\", expected KEY=VALUE", kv)
+		}
+	}
+
+	if _, err := s.secretsRegexp(); err != nil {
+		return err
+	}
+
+	if err := s.validateRequests(); err != nil {
+		return err
+	}
+
+	if !s.StartAt.IsZero() && s.StartAfter != 0 {
+		return fmt.Errorf("only one of start at or start after can be provided")
+	}
+
+	if s.StartAfter < 0 {
+		return fmt.Errorf("invalid negative start delay")
+	}
+
+	if err := s.Retry.validate(); err != nil {
+		return err
+	}
+
+	if err := s.Restart.validate(); err != nil {
+		return err
+	}
+
+	if s.Health != nil {
+		if err := s.Health.validate(&s.Restart); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// matches checks if the labels of the job match all the ones of the selector
+func (s *JobSpec) matches(selector map[string]string) bool {
+	for key, value := range selector {
+		if actual, ok := s.Labels[key]; !ok || actual != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// startTime returns when a delayed job has to start, or the zero time if it has to start now
+func (s *JobSpec) startTime(now time.Time) time.Time {
+	if s.StartAt.After(now) {
+		return s.StartAt
+	}
+
+	if s.StartAfter > 0 {
+		return now.Add(s.StartAfter)
+	}
+
+	return time.Time{}
+}
+
+// keepsStdinOpen checks if the stdin of the job has to be kept open after the initial input
+func (s *JobSpec) keepsStdinOpen() bool {
+	return s.OpenStdin || s.Tty
+}
+
+// cmdLine returns the command line of the job
+func (s *JobSpec) cmdLine() string {
+	return helpers.FormatCmdLine(s.Executable, s.Args...)
+}
+
+// environ returns the environment the job has to be started with
+func (s *JobSpec) environ() []string {
+	var env []string
+
+	if s.CleanEnv {
+		env = []string{}
+		for _, key := range s.InheritEnv {
+			if value, ok := os.LookupEnv(key); ok {
+				env = append(env, key+"="+value)
+			}
+		}
+	} else {
+		env = os.Environ()
+	}
+
+	return append(env, s.Env...)
+}
+
+const (
+	// childSpecEnv is the variable passing to the runner the file descriptor where it reads the environment and the
+	// working directory of the job, that it applies to the job only. They're passed through a pipe, so that they
+	// never show up in the command line arguments, and their size is not limited like the one of the environment.
+	childSpecEnv = "JOB_SCHEDULER_CHILD_SPEC_FD"
+	// childSpecFd is the file descriptor of the child spec (the first of ExtraFiles)
+	childSpecFd = 3
+)
+
+type childSpec struct {
+	Env []string
+	Dir string
+}
+
+// runnerEnviron returns the environment the runner has to be started with: only the PATH of the scheduler (where the
+// executable of the job is looked up), and the file descriptor of the child spec
+func runnerEnviron() []string {
+	env := []string{fmt.Sprintf("%s=%d", childSpecEnv, childSpecFd)}
+	if path, ok := os.LookupEnv("PATH"); ok {
+		env = append(env, "PATH="+path)
+	}
+
+	return env
+}
+
+// setupChildSpec passes the environment and the working directory of the job to the runner (see childSpecEnv).
+// It returns a function that releases the child end, that has to be called once the process is started.
+func (j *job) setupChildSpec() (func(), error) {
+	data, err := json.Marshal(&childSpec{Env: j.spec.environ(), Dir: j.spec.Dir})
+	if err != nil {
+		return nil, err
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+
+	j.cmd.ExtraFiles = []*os.File{r}
+
+	// The spec can be larger than the pipe buffer, so it's written while the runner reads it. If the runner doesn't
+	// read it, the write fails once the child end is closed.
+	go func() {
+		_, _ = w.Write(data)
+		_ = w.Close()
+	}()
+
+	return func() {
+		_ = r.Close()
+	}, nil
+}
+
+// readChildSpec reads the environment and the working directory of the job in the runner, or returns nil if they're
+// not passed
+func readChildSpec() (*childSpec, error) {
+	value, ok := os.LookupEnv(childSpecEnv)
+	if !ok {
+		return nil, nil
+	}
+	_ = os.Unsetenv(childSpecEnv)
+
+	fd, err := strconv.Atoi(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid job spec file descriptor \"%s\"", value)
+	}
+
+	f := os.NewFile(uintptr(fd), "spec")
+	defer f.Close()
+
+	cs := &childSpec{}
+	if err := json.NewDecoder(f).Decode(cs); err != nil {
+		return nil, fmt.Errorf("invalid job environment: %v", err)
+	}
+
+	return cs, nil
+}
+
+func copyStrings(values []string) []string {
+	return append([]string(nil), values...)
+}
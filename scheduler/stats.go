@@ -0,0 +1,61 @@
+package scheduler
+
+import (
+	"syscall"
+	"time"
+)
+
+// JobStats holds the resource usage of a job
+type JobStats struct {
+	// Time is when the stats have been collected
+	Time          time.Time
+	CpuUser       time.Duration
+	CpuSystem     time.Duration
+	MemoryCurrent int64
+	MemoryPeak    int64
+	IoReadBytes   int64
+	IoWriteBytes  int64
+	Pids          int
+	// Rusage is the usage reported by the kernel when the job exited (nil while it's running)
+	Rusage *syscall.Rusage
+}
+
+// stats collects the current resource usage of the job.
+// The usage is read from the job cgroups and, when they're not available, from the process itself.
+func (j *job) stats() *JobStats {
+	j.m.RLock("stats")
+	pid := j.sts.Pid
+	running := j.sts.Type == Running
+	rusage := j.rusage
+	usage := j.usage
+	j.m.RUnlock("stats")
+
+	st := &JobStats{Time: time.Now()}
+
+	if !running && usage != nil {
+		// The cgroups are removed once the process is over, keeping the usage accounted until then
+		*st = *usage
+		st.Time = time.Now()
+	} else if !readCgroupStats(j.id, st) && running && pid != 0 {
+		readProcStats(pid, st)
+	}
+
+	if rusage != nil {
+		usage := *rusage
+		st.Rusage = &usage
+
+		if st.CpuUser == 0 && st.CpuSystem == 0 {
+			st.CpuUser = time.Duration(usage.Utime.Nano())
+			st.CpuSystem = time.Duration(usage.Stime.Nano())
+		}
+		if st.MemoryPeak == 0 {
+			// Maxrss is reported in kB
+			st.MemoryPeak = usage.Maxrss * 1024
+		}
+		// The process is gone, so there's nothing using memory or PIDs anymore
+		st.MemoryCurrent = 0
+		st.Pids = 0
+	}
+
+	return st
+}
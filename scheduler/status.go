@@ -1,6 +1,10 @@
 package scheduler
 
-import "fmt"
+import (
+	"fmt"
+	"syscall"
+	"time"
+)
 
 type StatusType int
 
@@ -10,11 +14,63 @@ const (
 	Exited  StatusType = 2
 	Killed  StatusType = 3
 	Errored StatusType = 4
+	// Queued jobs are waiting for a slot to run (see WithMaxRunning), while Cancelled ones have been removed
+	// from the queue before starting
+	Queued    StatusType = 5
+	Cancelled StatusType = 6
+	// Preempted jobs have been stopped to make room for the ones with a higher priority, and are waiting in the
+	// queue to be started again
+	Preempted StatusType = 7
+	// Scheduled jobs are waiting for the time they have to start at
+	Scheduled StatusType = 8
+)
+
+// ExitReason explains why a job terminated, when the status and the exit code are not enough
+type ExitReason int
+
+const (
+	NoReason ExitReason = 0
+	// OOMKilled means that the job exceeded its memory limit and was killed by the kernel
+	OOMKilled ExitReason = 1
 )
 
+// Limits holds the resource limits applied to a job
+type Limits struct {
+	Memory int
+}
+
 type JobStatus struct {
 	Type     StatusType
 	ExitCode int
+	// Signal is the signal that terminated the process, if any.
+	// Since the runner reports it as an exit code above 128 (like a shell does), a job exiting with such a code on
+	// its own is reported as terminated by a signal too.
+	Signal syscall.Signal
+	// Pid is the PID on the host of the process started by the scheduler, while NsPid is the one inside its PID
+	// namespace (that's always 1). For an isolated job, that's the runner, which starts the job as its child.
+	Pid     int
+	NsPid   int
+	Command string
+	Limits  Limits
+	// Error describes why the job is in the Errored status
+	Error  string
+	Reason ExitReason
+	// PeakMemory is the maximum memory usage (in bytes) of the job, when running with a memory limit
+	PeakMemory int64
+	Created    time.Time
+	// StartAt is when a delayed job is going to start
+	StartAt  time.Time
+	Started  time.Time
+	Finished time.Time
+	// Attempt is the number of the current run of the job (starting from 1), while Attempts describes the
+	// finished ones (up to the last 64)
+	Attempt  int
+	Attempts []Attempt
+	// Restarts is the number of times the job has been restarted by its restart policy
+	Restarts int
+	// Health is the result of the health checks of the running job, and HealthError the reason of the last failed one
+	Health      Health
+	HealthError string
 }
 
 func (st StatusType) String() string {
@@ -27,23 +83,74 @@ func (st StatusType) String() string {
 		return "exited"
 	case Killed:
 		return "killed"
+	case Queued:
+		return "queued"
+	case Cancelled:
+		return "cancelled"
+	case Preempted:
+		return "preempted"
+	case Scheduled:
+		return "scheduled"
 	default:
 		return "errored"
 	}
 }
 
+// isFinal checks if a job with this status is over (i.e. it's never going to run again)
+func (st StatusType) isFinal() bool {
+	switch st {
+	case Exited, Errored, Killed, Cancelled:
+		return true
+	default:
+		return false
+	}
+}
+
+func (r ExitReason) String() string {
+	switch r {
+	case OOMKilled:
+		return "oom-killed"
+	default:
+		return ""
+	}
+}
+
 func (s *JobStatus) String() string {
 	switch s.Type {
-	case Idle, Running:
+	case Idle, Running, Queued, Scheduled:
 		return s.Type.String()
 	default:
-		return fmt.Sprintf("%s (%d)", s.Type, s.ExitCode)
+		details := fmt.Sprintf("%d", s.ExitCode)
+		if s.Signal != 0 {
+			details += fmt.Sprintf(", %s", s.Signal)
+		}
+		if s.Reason != NoReason {
+			details += fmt.Sprintf(", %s", s.Reason)
+		}
+		return fmt.Sprintf("%s (%s)", s.Type, details)
 	}
 }
 
 func (s *JobStatus) clone() *JobStatus {
 	return &JobStatus{
-		Type:     s.Type,
-		ExitCode: s.ExitCode,
+		Type:        s.Type,
+		ExitCode:    s.ExitCode,
+		Signal:      s.Signal,
+		Pid:         s.Pid,
+		NsPid:       s.NsPid,
+		Command:     s.Command,
+		Limits:      s.Limits,
+		Error:       s.Error,
+		Reason:      s.Reason,
+		PeakMemory:  s.PeakMemory,
+		Created:     s.Created,
+		StartAt:     s.StartAt,
+		Started:     s.Started,
+		Finished:    s.Finished,
+		Attempt:     s.Attempt,
+		Attempts:    append([]Attempt{}, s.Attempts...),
+		Restarts:    s.Restarts,
+		Health:      s.Health,
+		HealthError: s.HealthError,
 	}
 }
@@ -0,0 +1,216 @@
+package scheduler
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/beoboo/job-scheduler/library/log"
+	"github.com/beoboo/job-scheduler/library/stream"
+	"io"
+	"os"
+	"os/exec"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	// resizeFd is the file descriptor where the child receives the terminal size updates (the second of ExtraFiles,
+	// after the child spec)
+	resizeFd    = 4
+	defaultRows = 24
+	defaultCols = 80
+)
+
+// Terminal gives access to the pseudo-terminal of a job started with Tty
+type Terminal struct {
+	j *job
+}
+
+type winsize struct {
+	Rows   uint16
+	Cols   uint16
+	Xpixel uint16
+	Ypixel uint16
+}
+
+// Read returns the output of the terminal, that's the same stream of the job output.
+func (t *Terminal) Read() <-chan *stream.Line {
+	return t.j.output().Read()
+}
+
+// Write sends keystrokes to the terminal.
+func (t *Terminal) Write(p []byte) (int, error) {
+	in, err := t.j.input()
+	if err != nil {
+		return 0, err
+	}
+
+	return in.Write(p)
+}
+
+// Resize changes the size of the terminal window.
+func (t *Terminal) Resize(rows, cols uint16) error {
+	return t.j.resizeTty(rows, cols)
+}
+
+// terminal returns the pseudo-terminal of the job, if it's been started with one
+func (j *job) terminal() (*Terminal, error) {
+	if !j.spec.Tty {
+		return nil, fmt.Errorf("job \"%s\" has no terminal", j.id)
+	}
+
+	return &Terminal{j: j}, nil
+}
+
+// setupTty passes the channel used to resize the terminal to the child.
+// It returns a function that releases the child end, that has to be called once the process is started.
+func (j *job) setupTty() (func(), error) {
+	if !j.spec.Tty {
+		return func() {}, nil
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+
+	j.cmd.ExtraFiles = append(j.cmd.ExtraFiles, r)
+
+	j.m.WLock("setupTty")
+	j.resize = w
+	j.m.WUnlock("setupTty")
+
+	return func() {
+		_ = r.Close()
+	}, nil
+}
+
+func (j *job) resizeTty(rows, cols uint16) error {
+	j.m.RLock("resizeTty")
+	defer j.m.RUnlock("resizeTty")
+
+	if j.resize == nil {
+		return fmt.Errorf("job \"%s\" has no terminal", j.id)
+	}
+
+	_, err := fmt.Fprintf(j.resize, "%d %d\n", rows, cols)
+	return err
+}
+
+func (j *job) cleanupTty() {
+	j.m.WLock("cleanupTty")
+	defer j.m.WUnlock("cleanupTty")
+
+	if j.resize != nil {
+		_ = j.resize.Close()
+	}
+}
+
+// runTty runs the command attached to a new pseudo-terminal, relaying its input and output.
+// The terminal size is updated with the "ROWS COLS" lines read from resize.
+func runTty(cmd *exec.Cmd, stdin io.Reader, stdout io.Writer, resize io.Reader) error {
+	master, slave, err := openPty()
+	if err != nil {
+		return err
+	}
+	defer master.Close()
+
+	if err := setWinsize(master, defaultRows, defaultCols); err != nil {
+		log.Debugf("Cannot set terminal size: %v\n", err)
+	}
+
+	cmd.Stdin = slave
+	cmd.Stdout = slave
+	cmd.Stderr = slave
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setsid:  true,
+		Setctty: true,
+		Ctty:    0,
+	}
+
+	err = cmd.Start()
+	// The slave is only used by the command from now on
+	_ = slave.Close()
+	if err != nil {
+		return err
+	}
+
+	stopForwarding := forwardSignals(cmd.Process)
+	defer stopForwarding()
+
+	go func() {
+		_, _ = io.Copy(master, stdin)
+	}()
+
+	if resize != nil {
+		go func() {
+			scanner := bufio.NewScanner(resize)
+			for scanner.Scan() {
+				var rows, cols uint16
+				if _, err := fmt.Sscanf(scanner.Text(), "%d %d", &rows, &cols); err != nil {
+					continue
+				}
+				if err := setWinsize(master, rows, cols); err != nil {
+					log.Debugf("Cannot resize terminal: %v\n", err)
+				}
+			}
+		}()
+	}
+
+	// Reading from the master fails (with EIO) once the command closes its terminal
+	_, _ = io.Copy(stdout, master)
+
+	return cmd.Wait()
+}
+
+// openPty opens a new pseudo-terminal, returning its master and slave ends
+func openPty() (*os.File, *os.File, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot open pseudo-terminal: %v", err)
+	}
+
+	unlock := 0
+	if err := ioctl(master, syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); err != nil {
+		_ = master.Close()
+		return nil, nil, fmt.Errorf("cannot unlock pseudo-terminal: %v", err)
+	}
+
+	var n uint32
+	if err := ioctl(master, syscall.TIOCGPTN, uintptr(unsafe.Pointer(&n))); err != nil {
+		_ = master.Close()
+		return nil, nil, fmt.Errorf("cannot get pseudo-terminal number: %v", err)
+	}
+
+	slave, err := os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		_ = master.Close()
+		return nil, nil, fmt.Errorf("cannot open pseudo-terminal slave: %v", err)
+	}
+
+	return master, slave, nil
+}
+
+func setWinsize(f *os.File, rows, cols uint16) error {
+	ws := winsize{Rows: rows, Cols: cols}
+	return ioctl(f, syscall.TIOCSWINSZ, uintptr(unsafe.Pointer(&ws)))
+}
+
+func ioctl(f *os.File, req uint, arg uintptr) error {
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var errno syscall.Errno
+	err = conn.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, uintptr(req), arg)
+	})
+	if err != nil {
+		return err
+	}
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
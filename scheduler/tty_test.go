@@ -0,0 +1,51 @@
+package scheduler
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunTty(t *testing.T) {
+	stdinR, stdinW := io.Pipe()
+	resizeR, resizeW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resizeR.Close()
+
+	_, _ = resizeW.WriteString("30 100\n")
+
+	go func() {
+		// Gives some time for the resize to be applied
+		time.Sleep(100 * time.Millisecond)
+		_, _ = stdinW.Write([]byte("input\n"))
+	}()
+
+	var stdout bytes.Buffer
+	cmd := exec.Command("sh", "-c", "tty; read x; stty size; echo got $x")
+
+	err = runTty(cmd, stdinR, &stdout, resizeR)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := stdout.String()
+	for _, expected := range []string{"/dev/pts/", "30 100", "got input"} {
+		if !strings.Contains(res, expected) {
+			t.Fatalf("Expected \"%s\" to be in \"%s\"", expected, res)
+		}
+	}
+}
+
+func TestJobTerminalNotAvailable(t *testing.T) {
+	j := newJob(&wg)
+
+	if _, err := j.terminal(); err == nil {
+		t.Fatalf("Terminal should not be available")
+	}
+}
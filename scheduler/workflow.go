@@ -0,0 +1,374 @@
+package scheduler
+
+import (
+	"fmt"
+	"github.com/beoboo/job-scheduler/library/errors"
+	"github.com/beoboo/job-scheduler/library/log"
+	"github.com/beoboo/job-scheduler/library/logsync"
+)
+
+const (
+	// WorkflowLabel is the label linking the jobs started by a workflow to it
+	WorkflowLabel = "workflow"
+	// StepLabel is the label with the name of the workflow step that started a job
+	StepLabel = "step"
+)
+
+// Condition sets when a step runs, according to the final status of one of its dependencies
+type Condition int
+
+const (
+	// OnSuccess runs the step if the dependency has exited successfully
+	OnSuccess Condition = 0
+	// OnFailure runs the step if the dependency has failed (errored or killed)
+	OnFailure Condition = 1
+	// Always runs the step once the dependency is over, whatever its status
+	Always Condition = 2
+)
+
+// Dependency is an edge of a workflow, from the step it refers to, to the step depending on it
+type Dependency struct {
+	Step      string
+	Condition Condition
+}
+
+// WorkflowStep is a job of a workflow, started once all its dependencies are over and their conditions are met
+type WorkflowStep struct {
+	Name      string
+	Job       JobSpec
+	DependsOn []Dependency
+}
+
+// WorkflowSpec describes a DAG of jobs, run by the Scheduler
+type WorkflowSpec struct {
+	Steps []WorkflowStep
+	// MaxParallel limits the number of steps running at the same time (0 means no limit)
+	MaxParallel int
+}
+
+// StepStatus describes the state of a workflow step.
+// A step that's not started yet is Idle (with no job), and one whose conditions are not met is Cancelled.
+type StepStatus struct {
+	Name  string
+	JobId string
+	Type  StatusType
+	Error string
+}
+
+// WorkflowStatus describes the state of a workflow, and of its steps (in the order of the spec).
+// The workflow is Running until all its steps are over, then it's Errored if any of them has failed, or Exited.
+type WorkflowStatus struct {
+	Type  StatusType
+	Steps []StepStatus
+}
+
+// workflow starts the steps of a WorkflowSpec as soon as their dependencies are over
+type workflow struct {
+	id      string
+	spec    *WorkflowSpec
+	steps   []*step
+	s       *Scheduler
+	running int
+	over    bool
+	m       logsync.Mutex
+}
+
+type step struct {
+	spec  *WorkflowStep
+	job   *job
+	st    StatusType
+	err   string
+	deps  []*step
+	conds []Condition
+}
+
+// SubmitWorkflow starts a workflow, returning its ID, or an error if the spec is not valid (i.e. it has a cycle).
+// Every step is a normal job, labelled with the ID of the workflow and the name of the step (see WorkflowLabel and
+// StepLabel). Wait waits for the whole workflow.
+func (s *Scheduler) SubmitWorkflow(spec *WorkflowSpec) (string, error) {
+	log.Debugf("Submitting workflow with %d steps\n", len(spec.Steps))
+
+	if err := spec.validate(); err != nil {
+		return "", err
+	}
+
+	id := generateRandomId()
+	w := &workflow{
+		id:   id,
+		spec: spec.copy(),
+		s:    s,
+		m:    logsync.NewMutex(fmt.Sprintf("workflow %s", id)),
+	}
+
+	byName := make(map[string]*step, len(w.spec.Steps))
+	for i := range w.spec.Steps {
+		st := &step{spec: &w.spec.Steps[i], st: Idle}
+		w.steps = append(w.steps, st)
+		byName[st.spec.Name] = st
+	}
+	for _, st := range w.steps {
+		for _, dep := range st.spec.DependsOn {
+			st.deps = append(st.deps, byName[dep.Step])
+			st.conds = append(st.conds, dep.Condition)
+		}
+	}
+
+	s.m.WLock("SubmitWorkflow")
+	s.workflows[id] = w
+	s.wg.Add(id, 1)
+	s.m.WUnlock("SubmitWorkflow")
+
+	w.advance()
+
+	return id, nil
+}
+
+// WorkflowStatus returns the status of a workflow, or an error if it doesn't exist.
+func (s *Scheduler) WorkflowStatus(id string) (*WorkflowStatus, error) {
+	s.m.RLock("WorkflowStatus")
+	w, ok := s.workflows[id]
+	s.m.RUnlock("WorkflowStatus")
+
+	if !ok {
+		return nil, &errors.NotFoundError{Id: id}
+	}
+
+	w.m.RLock("WorkflowStatus")
+	defer w.m.RUnlock("WorkflowStatus")
+
+	ws := &WorkflowStatus{Type: Running}
+	failed := false
+	for _, st := range w.steps {
+		ss := StepStatus{Name: st.spec.Name, Type: st.st, Error: st.err}
+		if st.job != nil {
+			js := st.job.status()
+			ss.JobId = st.job.id
+			ss.Type = js.Type
+			ss.Error = js.Error
+		}
+		failed = failed || ss.Type == Errored || ss.Type == Killed
+		ws.Steps = append(ws.Steps, ss)
+	}
+
+	if w.over {
+		ws.Type = Exited
+		if failed {
+			ws.Type = Errored
+		}
+	}
+
+	return ws, nil
+}
+
+// validate checks that the workflow is a DAG of valid steps
+func (spec *WorkflowSpec) validate() error {
+	if len(spec.Steps) == 0 {
+		return fmt.Errorf("workflow has no steps")
+	}
+
+	if spec.MaxParallel < 0 {
+		return fmt.Errorf("invalid negative max parallel steps")
+	}
+
+	steps := make(map[string]*WorkflowStep, len(spec.Steps))
+	for i := range spec.Steps {
+		st := &spec.Steps[i]
+		if st.Name == "" {
+			return fmt.Errorf("workflow step %d has no name", i)
+		}
+		if _, ok := steps[st.Name]; ok {
+			return fmt.Errorf("duplicate workflow step \"%s\"", st.Name)
+		}
+		if err := st.Job.validate(); err != nil {
+			return fmt.Errorf("invalid workflow step \"%s\": %v", st.Name, err)
+		}
+
+		steps[st.Name] = st
+	}
+
+	for _, st := range spec.Steps {
+		for _, dep := range st.DependsOn {
+			if _, ok := steps[dep.Step]; !ok {
+				return fmt.Errorf("workflow step \"%s\" depends on unknown step \"%s\"", st.Name, dep.Step)
+			}
+			if dep.Condition < OnSuccess || dep.Condition > Always {
+				return fmt.Errorf("invalid condition %d for workflow step \"%s\"", dep.Condition, st.Name)
+			}
+		}
+	}
+
+	// Depth-first search, looking for a step that's reached again while visiting its dependencies
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int, len(steps))
+
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case visiting:
+			return fmt.Errorf("workflow has a cycle through step \"%s\"", name)
+		case visited:
+			return nil
+		}
+
+		state[name] = visiting
+		for _, dep := range steps[name].DependsOn {
+			if err := visit(dep.Step); err != nil {
+				return err
+			}
+		}
+		state[name] = visited
+
+		return nil
+	}
+
+	for _, st := range spec.Steps {
+		if err := visit(st.Name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// copy copies the spec, so that the caller can't change it while the workflow is running
+func (spec *WorkflowSpec) copy() *WorkflowSpec {
+	copied := *spec
+	copied.Steps = make([]WorkflowStep, len(spec.Steps))
+	for i, st := range spec.Steps {
+		copied.Steps[i] = st
+		copied.Steps[i].Job = *st.Job.clone()
+		copied.Steps[i].DependsOn = append([]Dependency{}, st.DependsOn...)
+	}
+
+	return &copied
+}
+
+// advance starts the steps whose dependencies are over, and cancels the ones whose conditions can't be met anymore
+func (w *workflow) advance() {
+	w.m.WLock("advance")
+	defer w.m.WUnlock("advance")
+
+	if w.over {
+		return
+	}
+
+	// Cancelling a step can make the ones depending on it ready, so the steps are checked until nothing changes
+	for changed := true; changed; {
+		changed = false
+
+		for _, st := range w.steps {
+			if st.job != nil || st.st != Idle {
+				continue
+			}
+
+			ready, run := st.ready()
+			if !ready {
+				continue
+			}
+
+			if !run {
+				log.Debugf("Cancelling step \"%s\" of workflow %s\n", st.spec.Name, w.id)
+				st.st = Cancelled
+				changed = true
+				continue
+			}
+
+			if w.spec.MaxParallel > 0 && w.running >= w.spec.MaxParallel {
+				continue
+			}
+
+			w.start(st)
+			changed = true
+		}
+	}
+
+	for _, st := range w.steps {
+		if !st.isOver() {
+			return
+		}
+	}
+
+	log.Debugf("Workflow %s is over\n", w.id)
+	w.over = true
+	w.s.wg.Done(w.id)
+}
+
+// start starts the job of a step, watching it until it's over (the lock has to be held)
+func (w *workflow) start(st *step) {
+	spec := st.spec.Job
+	spec.Labels = map[string]string{}
+	for key, value := range st.spec.Job.Labels {
+		spec.Labels[key] = value
+	}
+	spec.Labels[WorkflowLabel] = w.id
+	spec.Labels[StepLabel] = st.spec.Name
+
+	id, err := w.s.StartJob(&spec)
+	if id != "" {
+		w.s.m.RLock("start")
+		st.job = w.s.jobs[id]
+		w.s.m.RUnlock("start")
+	}
+
+	if err != nil {
+		log.Debugf("Cannot start step \"%s\" of workflow %s: %v\n", st.spec.Name, w.id, err)
+		st.st = Errored
+		st.err = err.Error()
+		return
+	}
+
+	w.running += 1
+
+	go func() {
+		<-st.job.done
+
+		w.m.WLock("step")
+		w.running -= 1
+		w.m.WUnlock("step")
+
+		w.advance()
+	}()
+}
+
+// ready checks if all the dependencies of the step are over, and if their conditions are met (so that it has to run)
+func (st *step) ready() (bool, bool) {
+	run := true
+	for i, dep := range st.deps {
+		if !dep.isOver() {
+			return false, false
+		}
+
+		run = run && dep.meets(st.conds[i])
+	}
+
+	return true, run
+}
+
+// isOver checks if the step has reached a final status (or has been cancelled)
+func (st *step) isOver() bool {
+	return st.status().isFinal()
+}
+
+// meets checks if the final status of the step meets a condition
+func (st *step) meets(cond Condition) bool {
+	switch status := st.status(); cond {
+	case OnSuccess:
+		return status == Exited
+	case OnFailure:
+		return status == Errored || status == Killed
+	default:
+		return true
+	}
+}
+
+func (st *step) status() StatusType {
+	if st.job != nil {
+		return st.job.status().Type
+	}
+
+	return st.st
+}
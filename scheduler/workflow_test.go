@@ -0,0 +1,139 @@
+package scheduler
+
+import (
+	"testing"
+)
+
+func TestSchedulerWorkflow(t *testing.T) {
+	s := New(QueueRunner)
+
+	// a -> (b, c) -> d
+	id, err := s.SubmitWorkflow(&WorkflowSpec{
+		Steps: []WorkflowStep{
+			{Name: "a", Job: JobSpec{Executable: "sleep", Args: []string{"0.1"}}},
+			{Name: "b", Job: JobSpec{Executable: "sleep", Args: []string{"0.1"}}, DependsOn: []Dependency{{Step: "a"}}},
+			{Name: "c", Job: JobSpec{Executable: "sleep", Args: []string{"0.1"}}, DependsOn: []Dependency{{Step: "a"}}},
+			{Name: "d", Job: JobSpec{Executable: "sleep", Args: []string{"0"}}, DependsOn: []Dependency{{Step: "b"}, {Step: "c"}}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertWorkflowStatus(t, s, id, Running, Running, Idle, Idle, Idle)
+
+	s.Wait()
+
+	ws := assertWorkflowStatus(t, s, id, Exited, Exited, Exited, Exited, Exited)
+
+	// Independent steps run in parallel, and a step starts only once its dependencies are over
+	a, _ := s.Status(ws.Steps[0].JobId)
+	b, _ := s.Status(ws.Steps[1].JobId)
+	c, _ := s.Status(ws.Steps[2].JobId)
+	d, _ := s.Status(ws.Steps[3].JobId)
+	if b.Started.Before(a.Finished) || c.Started.Before(a.Finished) {
+		t.Fatal("Steps b and c should start after a is over")
+	}
+	if b.Started.After(c.Finished) || c.Started.After(b.Finished) {
+		t.Fatal("Steps b and c should run in parallel")
+	}
+	if d.Started.Before(b.Finished) || d.Started.Before(c.Finished) {
+		t.Fatal("Step d should start after b and c are over")
+	}
+
+	if s.jobs[ws.Steps[3].JobId].spec.Labels[WorkflowLabel] != id || s.jobs[ws.Steps[3].JobId].spec.Labels[StepLabel] != "d" {
+		t.Fatalf("Step job should be labelled with workflow %s and step d", id)
+	}
+}
+
+func TestSchedulerWorkflowConditions(t *testing.T) {
+	s := New(QueueRunner)
+
+	id, _ := s.SubmitWorkflow(&WorkflowSpec{
+		Steps: []WorkflowStep{
+			{Name: "build", Job: JobSpec{Executable: "sh", Args: []string{"-c", "exit 1"}}},
+			{Name: "test", Job: JobSpec{Executable: "true"}, DependsOn: []Dependency{{Step: "build"}}},
+			{Name: "deploy", Job: JobSpec{Executable: "true"}, DependsOn: []Dependency{{Step: "test"}}},
+			{Name: "notify", Job: JobSpec{Executable: "true"}, DependsOn: []Dependency{{Step: "build", Condition: OnFailure}}},
+			{Name: "cleanup", Job: JobSpec{Executable: "true"}, DependsOn: []Dependency{{Step: "deploy", Condition: Always}}},
+		},
+	})
+
+	s.Wait()
+
+	ws := assertWorkflowStatus(t, s, id, Errored, Errored, Cancelled, Cancelled, Exited, Exited)
+	if ws.Steps[1].JobId != "" {
+		t.Fatalf("Cancelled step should have no job, got %s", ws.Steps[1].JobId)
+	}
+}
+
+func TestSchedulerWorkflowMaxParallel(t *testing.T) {
+	s := New(QueueRunner)
+
+	id, _ := s.SubmitWorkflow(&WorkflowSpec{
+		Steps: []WorkflowStep{
+			{Name: "a", Job: JobSpec{Executable: "sleep", Args: []string{"0.1"}}},
+			{Name: "b", Job: JobSpec{Executable: "sleep", Args: []string{"0.1"}}},
+			{Name: "c", Job: JobSpec{Executable: "sleep", Args: []string{"0.1"}}},
+		},
+		MaxParallel: 2,
+	})
+
+	assertWorkflowStatus(t, s, id, Running, Running, Running, Idle)
+
+	s.Wait()
+
+	assertWorkflowStatus(t, s, id, Exited, Exited, Exited, Exited)
+}
+
+func TestSchedulerWorkflowValidation(t *testing.T) {
+	s := New(QueueRunner)
+
+	tests := []*WorkflowSpec{
+		{},
+		{Steps: []WorkflowStep{{Job: JobSpec{Executable: "true"}}}},
+		{Steps: []WorkflowStep{{Name: "a", Job: JobSpec{Executable: "true"}}, {Name: "a", Job: JobSpec{Executable: "true"}}}},
+		{Steps: []WorkflowStep{{Name: "a", Job: JobSpec{}}}},
+		{Steps: []WorkflowStep{{Name: "a", Job: JobSpec{Executable: "true"}, DependsOn: []Dependency{{Step: "b"}}}}},
+		{Steps: []WorkflowStep{{Name: "a", Job: JobSpec{Executable: "true"}, DependsOn: []Dependency{{Step: "a"}}}}},
+		{Steps: []WorkflowStep{
+			{Name: "a", Job: JobSpec{Executable: "true"}, DependsOn: []Dependency{{Step: "c"}}},
+			{Name: "b", Job: JobSpec{Executable: "true"}, DependsOn: []Dependency{{Step: "a"}}},
+			{Name: "c", Job: JobSpec{Executable: "true"}, DependsOn: []Dependency{{Step: "b"}}},
+		}},
+		{Steps: []WorkflowStep{{Name: "a", Job: JobSpec{Executable: "true"}}}, MaxParallel: -1},
+	}
+
+	for _, test := range tests {
+		if _, err := s.SubmitWorkflow(test); err == nil {
+			t.Fatalf("Workflow %+v should not be valid", test)
+		}
+	}
+
+	if s.Size() != 0 {
+		t.Fatalf("No job should be started, got %d", s.Size())
+	}
+}
+
+func assertWorkflowStatus(t *testing.T, s *Scheduler, id string, expectedType StatusType, expectedSteps ...StatusType) *WorkflowStatus {
+	ws, err := s.WorkflowStatus(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ws.Type != expectedType {
+		t.Fatalf("Workflow status should be \"%s\", got \"%s\"", expectedType, ws.Type)
+	}
+
+	if len(ws.Steps) != len(expectedSteps) {
+		t.Fatalf("Expected %d steps, got %d", len(expectedSteps), len(ws.Steps))
+	}
+
+	for i, st := range ws.Steps {
+		if st.Type != expectedSteps[i] {
+			t.Fatalf("Step \"%s\" status should be \"%s\", got \"%s\"", st.Name, expectedSteps[i], st.Type)
+		}
+	}
+
+	return ws
+}
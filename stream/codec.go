@@ -0,0 +1,46 @@
+package stream
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+// headerSize is the size of the header of an encoded Line: its time (8 bytes), type (1 byte) and text length (4 bytes)
+const headerSize = 8 + 1 + 4
+
+// encodeLine writes a Line in a binary format, returning the number of bytes written
+func encodeLine(w io.Writer, l *Line) (int, error) {
+	buf := make([]byte, headerSize+len(l.Text))
+	binary.BigEndian.PutUint64(buf[0:8], uint64(l.Time.UnixNano()))
+	buf[8] = byte(l.Type)
+	binary.BigEndian.PutUint32(buf[9:13], uint32(len(l.Text)))
+	copy(buf[headerSize:], l.Text)
+
+	return w.Write(buf)
+}
+
+// decodeLine reads a Line written by encodeLine, returning io.EOF if there are no more lines
+func decodeLine(r io.Reader) (*Line, error) {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	text := make([]byte, textSize(header))
+	if _, err := io.ReadFull(r, text); err != nil {
+		return nil, fmt.Errorf("truncated line: %v", err)
+	}
+
+	return &Line{
+		Time: time.Unix(0, int64(binary.BigEndian.Uint64(header[0:8]))),
+		Type: StreamType(header[8]),
+		Text: text,
+	}, nil
+}
+
+// textSize returns the length of the text of an encoded Line, from its header
+func textSize(header []byte) int {
+	return int(binary.BigEndian.Uint32(header[9:13]))
+}
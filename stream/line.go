@@ -9,6 +9,11 @@ type Line struct {
 	Time time.Time
 	Type StreamType
 	Text []byte
+	// Pos is the position of the line in the Stream, that can be used as a cursor to resume reading it.
+	// Skipped is the number of lines dropped (due to the memory cap of the Stream) before a reader could read them.
+	// They're only set on the lines returned by Stream.Read.
+	Pos     int
+	Skipped int
 }
 
 type Lines = []Line
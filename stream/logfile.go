@@ -0,0 +1,125 @@
+package stream
+
+import (
+	"fmt"
+	"os"
+)
+
+// LogFile persists the lines of a Stream to an append-only file, rotating it when it reaches a max size.
+// Rotated files are named PATH.1 (the most recent), PATH.2, and so on.
+type LogFile struct {
+	path     string
+	f        *os.File
+	size     int64
+	maxSize  int64
+	maxFiles int
+	rotated  int
+}
+
+// OpenLogFile opens (or creates) a log file, that's rotated once it reaches maxSize bytes (0 means no rotation).
+// Only maxFiles rotated files are kept (0 means all of them).
+func OpenLogFile(path string, maxSize int64, maxFiles int) (*LogFile, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	return &LogFile{
+		path:     path,
+		f:        f,
+		size:     info.Size(),
+		maxSize:  maxSize,
+		maxFiles: maxFiles,
+		rotated:  len(rotatedFiles(path)),
+	}, nil
+}
+
+func (lf *LogFile) write(l *Line) error {
+	if lf.maxSize > 0 && lf.size > 0 && lf.size+int64(headerSize+len(l.Text)) > lf.maxSize {
+		if err := lf.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := encodeLine(lf.f, l)
+	lf.size += int64(n)
+
+	return err
+}
+
+// rotate moves the current file to PATH.1 (shifting the rotated ones), and starts a new one
+func (lf *LogFile) rotate() error {
+	if err := lf.f.Close(); err != nil {
+		return err
+	}
+
+	for i := lf.rotated; i >= 1; i-- {
+		if lf.maxFiles > 0 && i >= lf.maxFiles {
+			_ = os.Remove(rotatedPath(lf.path, i))
+			continue
+		}
+
+		if err := os.Rename(rotatedPath(lf.path, i), rotatedPath(lf.path, i+1)); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Rename(lf.path, rotatedPath(lf.path, 1)); err != nil {
+		return err
+	}
+
+	lf.rotated += 1
+	if lf.maxFiles > 0 && lf.rotated > lf.maxFiles {
+		lf.rotated = lf.maxFiles
+	}
+
+	f, err := os.OpenFile(lf.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	lf.f = f
+	lf.size = 0
+
+	return nil
+}
+
+func (lf *LogFile) close() {
+	_ = lf.f.Close()
+}
+
+func rotatedPath(path string, i int) string {
+	return fmt.Sprintf("%s.%d", path, i)
+}
+
+// logFiles returns all the files of a log, from the oldest one
+func logFiles(path string) []string {
+	rotated := rotatedFiles(path)
+
+	files := make([]string, 0, len(rotated)+1)
+	for i := len(rotated) - 1; i >= 0; i-- {
+		files = append(files, rotated[i])
+	}
+
+	return append(files, path)
+}
+
+// rotatedFiles returns the rotated files of a log, from the most recent one
+func rotatedFiles(path string) []string {
+	var files []string
+
+	for i := 1; ; i++ {
+		rotated := rotatedPath(path, i)
+		if _, err := os.Stat(rotated); err != nil {
+			return files
+		}
+
+		files = append(files, rotated)
+	}
+}
@@ -0,0 +1,106 @@
+package stream
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLogFilePersistsStream(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "job.log")
+	s := newLoggedStream(t, path, 0, 0)
+
+	expected := Line{Time: time.Now(), Type: Error, Text: []byte("error")}
+	_ = s.Write(buildLine("output"))
+	_ = s.Write(expected)
+	s.Close()
+
+	o, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !o.IsClosed() {
+		t.Fatalf("Opened stream should be closed")
+	}
+
+	if o.spill.files != nil {
+		t.Fatalf("Opened stream should not keep its files open")
+	}
+
+	lines := readAll(o)
+	if len(lines) != 2 {
+		t.Fatalf("Expected %d lines, got %d", 2, len(lines))
+	}
+	assertLine(t, lines[0], "output")
+	if !lines[1].Time.Equal(expected.Time) || lines[1].Type != expected.Type || string(lines[1].Text) != "error" {
+		t.Fatalf("Expected %s, got %s", expected.String(), lines[1])
+	}
+}
+
+func TestLogFileRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "job.log")
+	// Each line takes 17 bytes (13 for the header and 4 for the text), so every file holds 2 lines
+	s := newLoggedStream(t, path, 40, 0)
+	expected := []string{"#001", "#002", "#003", "#004", "#005"}
+	writeAll(s, expected...)
+	s.Close()
+
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		if _, err := os.Stat(name); err != nil {
+			t.Fatalf("Expected log file \"%s\" to exist", name)
+		}
+	}
+
+	o, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := readAll(o)
+	if len(lines) != len(expected) {
+		t.Fatalf("Expected %d lines, got %d", len(expected), len(lines))
+	}
+	for i, e := range expected {
+		assertLine(t, lines[i], e)
+	}
+}
+
+func TestLogFileRotationMaxFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "job.log")
+	s := newLoggedStream(t, path, 40, 1)
+	writeAll(s, "#001", "#002", "#003", "#004", "#005")
+	s.Close()
+
+	if _, err := os.Stat(path + ".2"); err == nil {
+		t.Fatalf("Only one rotated file should be kept")
+	}
+
+	o, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := readAll(o)
+	if len(lines) != 3 {
+		t.Fatalf("Expected %d lines, got %d", 3, len(lines))
+	}
+	assertLine(t, lines[0], "#003")
+}
+
+func TestOpenUnknownLogFile(t *testing.T) {
+	_, err := Open(filepath.Join(t.TempDir(), "unknown.log"))
+	if !os.IsNotExist(err) {
+		t.Fatalf("Opening an unknown log file should fail, got %v", err)
+	}
+}
+
+func newLoggedStream(t *testing.T, path string, maxSize int64, maxFiles int) *Stream {
+	lf, err := OpenLogFile(path, maxSize, maxFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return New(WithLogFile(lf))
+}
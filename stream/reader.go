@@ -0,0 +1,46 @@
+package stream
+
+import (
+	"context"
+	"io"
+)
+
+// Reader exposes the text of the lines of a Stream as a plain byte stream
+type Reader struct {
+	lines  <-chan *Line
+	cancel context.CancelFunc
+	buf    []byte
+}
+
+// NewReader creates a Reader over the lines of a stream read with opts (i.e. only the ones of a type).
+// It returns io.EOF once the lines are over, and it has to be closed if it's not read until then.
+func NewReader(s *Stream, opts ...ReadOption) *Reader {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Reader{
+		lines:  s.ReadContext(ctx, opts...),
+		cancel: cancel,
+	}
+}
+
+// Read reads the text of the lines, blocking until one is available.
+func (r *Reader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		l, ok := <-r.lines
+		if !ok {
+			return 0, io.EOF
+		}
+		r.buf = l.Text
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+
+	return n, nil
+}
+
+// Close releases the reader.
+func (r *Reader) Close() error {
+	r.cancel()
+	return nil
+}
@@ -0,0 +1,146 @@
+package stream
+
+import (
+	"bytes"
+	"context"
+	"regexp"
+)
+
+// Match is a line matching a search, with the lines around it.
+// The context lines of close matches can overlap.
+type Match struct {
+	*Line
+	Before []*Line
+	After  []*Line
+}
+
+// SearchOption configures Stream.Search.
+type SearchOption func(o *searchOptions)
+
+type searchOptions struct {
+	regexp bool
+	before int
+	after  int
+	follow bool
+}
+
+// AsRegexp interprets the pattern as a regular expression, instead of a plain substring.
+func AsRegexp() SearchOption {
+	return func(o *searchOptions) {
+		o.regexp = true
+	}
+}
+
+// ContextLines returns up to before lines preceding every match, and up to after lines following it.
+func ContextLines(before, after int) SearchOption {
+	return func(o *searchOptions) {
+		o.before = before
+		o.after = after
+	}
+}
+
+// Following keeps searching the new lines written to the stream, until it's closed.
+func Following() SearchOption {
+	return func(o *searchOptions) {
+		o.follow = true
+	}
+}
+
+// Search returns a channel of the lines matching pattern (including the ones spilled to disk), or an error if the
+// pattern is not a valid regular expression.
+// By default, only the lines available when starting are searched (see SearchOption).
+// A match is sent once its following context lines are available, or the search is over.
+func (s *Stream) Search(ctx context.Context, pattern string, opts ...SearchOption) (<-chan *Match, error) {
+	o := searchOptions{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	matches, err := o.matcher(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	var readOpts []ReadOption
+	if !o.follow {
+		readOpts = append(readOpts, NoFollow())
+	}
+
+	lines := s.ReadContext(ctx, readOpts...)
+	next := make(chan *Match)
+
+	go func() {
+		defer close(next)
+
+		var before []*Line
+		var pending []*Match
+
+		send := func(m *Match) bool {
+			select {
+			case next <- m:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		for l := range lines {
+			if l.Skipped > 0 {
+				// The lines before are not contiguous with this one anymore
+				before = nil
+			}
+
+			// The pending matches are still waiting for their following lines
+			for _, m := range pending {
+				m.After = append(m.After, l)
+			}
+
+			if matches(l.Text) {
+				pending = append(pending, &Match{
+					Line:   l,
+					Before: append([]*Line{}, before...),
+				})
+			}
+
+			// The matches are sent in order, as soon as their context is complete
+			for len(pending) > 0 && len(pending[0].After) == o.after {
+				if !send(pending[0]) {
+					return
+				}
+				pending = pending[1:]
+			}
+
+			if o.before > 0 {
+				before = append(before, l)
+				if len(before) > o.before {
+					before = before[1:]
+				}
+			}
+		}
+
+		for _, m := range pending {
+			if !send(m) {
+				return
+			}
+		}
+	}()
+
+	return next, nil
+}
+
+// matcher returns the function matching the text of a line against pattern
+func (o *searchOptions) matcher(pattern string) (func(text []byte) bool, error) {
+	if !o.regexp {
+		substr := []byte(pattern)
+		return func(text []byte) bool {
+			return bytes.Contains(text, substr)
+		}, nil
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	return re.Match, nil
+}
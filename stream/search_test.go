@@ -0,0 +1,104 @@
+package stream
+
+import (
+	"context"
+	"testing"
+)
+
+func TestStreamSearch(t *testing.T) {
+	s := New()
+	writeAll(s, "first", "error: one", "second", "third", "error: two")
+	s.Close()
+
+	matches := searchAll(t, s, "error")
+
+	assertMatches(t, matches, "error: one", "error: two")
+	if matches[0].Pos != 1 || matches[1].Pos != 4 {
+		t.Fatalf("Expected matches at 1 and 4, got %d and %d", matches[0].Pos, matches[1].Pos)
+	}
+}
+
+func TestStreamSearchRegexp(t *testing.T) {
+	s := New()
+	writeAll(s, "error: 1", "error: a", "warning: 2")
+	s.Close()
+
+	assertMatches(t, searchAll(t, s, `^\w+: \d$`, AsRegexp()), "error: 1", "warning: 2")
+
+	if _, err := s.Search(context.Background(), "(", AsRegexp()); err == nil {
+		t.Fatalf("An invalid regexp should fail")
+	}
+}
+
+func TestStreamSearchContextLines(t *testing.T) {
+	s := New()
+	writeAll(s, "#1", "#2", "match", "#4", "#5", "#6", "match")
+	s.Close()
+
+	matches := searchAll(t, s, "match", ContextLines(2, 1))
+
+	assertMatches(t, matches, "match", "match")
+	assertLines(t, matches[0].Before, "#1", "#2")
+	assertLines(t, matches[0].After, "#4")
+	assertLines(t, matches[1].Before, "#5", "#6")
+	// The last match is sent even if the following lines are not available
+	assertLines(t, matches[1].After)
+}
+
+func TestStreamSearchSpilled(t *testing.T) {
+	s := New(WithMaxBytes(8), WithSpillDir(t.TempDir()))
+	writeAll(s, "#001", "#002", "#003", "#004", "#005")
+	s.Close()
+
+	assertMatches(t, searchAll(t, s, "#00"), "#001", "#002", "#003", "#004", "#005")
+}
+
+func TestStreamSearchFollowing(t *testing.T) {
+	s := New()
+	writeAll(s, "match #1")
+
+	matches, err := s.Search(context.Background(), "match", Following())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertMatch(t, <-matches, "match #1")
+
+	writeAll(s, "other", "match #2")
+	assertMatch(t, <-matches, "match #2")
+
+	s.Close()
+	if _, ok := <-matches; ok {
+		t.Fatalf("The search should be over when the stream is closed")
+	}
+}
+
+func searchAll(t *testing.T, s *Stream, pattern string, opts ...SearchOption) []*Match {
+	matches, err := s.Search(context.Background(), pattern, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var all []*Match
+	for m := range matches {
+		all = append(all, m)
+	}
+
+	return all
+}
+
+func assertMatches(t *testing.T, matches []*Match, expected ...string) {
+	if len(matches) != len(expected) {
+		t.Fatalf("Expected %d matches, got %d", len(expected), len(matches))
+	}
+
+	for i, e := range expected {
+		assertMatch(t, matches[i], e)
+	}
+}
+
+func assertMatch(t *testing.T, m *Match, expected string) {
+	if string(m.Text) != expected {
+		t.Fatalf("Expected match \"%s\", got \"%s\"", expected, m.Text)
+	}
+}
@@ -0,0 +1,136 @@
+package stream
+
+import (
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+)
+
+// segment holds lines stored on disk, in one or more files written with encodeLine.
+// New lines can only be appended to the last file.
+type segment struct {
+	paths []string
+	// files are the open files of the segment, or nil once it's closed (then they're opened on every read)
+	files []*os.File
+	index []position
+	size  int64
+}
+
+// position locates a line in the files of a segment
+type position struct {
+	file   int
+	offset int64
+}
+
+// newSegment creates a segment backed by a new temporary file in dir
+func newSegment(dir string) (*segment, error) {
+	f, err := ioutil.TempFile(dir, "stream-*.seg")
+	if err != nil {
+		return nil, err
+	}
+
+	return &segment{paths: []string{f.Name()}, files: []*os.File{f}}, nil
+}
+
+// openSegment creates a closed segment over existing files, indexing their lines
+func openSegment(paths []string) (*segment, error) {
+	sg := &segment{paths: paths}
+
+	for i, path := range paths {
+		if err := sg.scan(i, path); err != nil {
+			return nil, err
+		}
+	}
+
+	return sg, nil
+}
+
+// scan indexes all the lines of a file, skipping their text
+func (sg *segment) scan(file int, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	header := make([]byte, headerSize)
+	for offset := int64(0); ; {
+		// A truncated line is expected at the end, if the writer was interrupted
+		if _, err := f.ReadAt(header, offset); err != nil {
+			return nil
+		}
+
+		end := offset + int64(headerSize+textSize(header))
+		if end > info.Size() {
+			return nil
+		}
+
+		sg.index = append(sg.index, position{file: file, offset: offset})
+		sg.size = end
+		offset = end
+	}
+}
+
+// append adds a line at the end of the segment
+func (sg *segment) append(l *Line) error {
+	n, err := encodeLine(sg.files[len(sg.files)-1], l)
+	if err != nil {
+		return err
+	}
+
+	sg.index = append(sg.index, position{file: len(sg.files) - 1, offset: sg.size})
+	sg.size += int64(n)
+
+	return nil
+}
+
+// read returns the line at position i
+func (sg *segment) read(i int) (*Line, error) {
+	pos := sg.index[i]
+
+	f, err := sg.file(pos.file)
+	if err != nil {
+		return nil, err
+	}
+	if sg.files == nil {
+		defer f.Close()
+	}
+
+	return decodeLine(io.NewSectionReader(f, pos.offset, math.MaxInt64-pos.offset))
+}
+
+// file returns an open file of the segment, opening it again if the segment is closed
+func (sg *segment) file(i int) (*os.File, error) {
+	if sg.files != nil {
+		return sg.files[i], nil
+	}
+
+	return os.Open(sg.paths[i])
+}
+
+func (sg *segment) len() int {
+	return len(sg.index)
+}
+
+// close closes the files of the segment, that can still be read (opening them on every read)
+func (sg *segment) close() {
+	for _, f := range sg.files {
+		_ = f.Close()
+	}
+	sg.files = nil
+}
+
+// remove deletes the segment files
+func (sg *segment) remove() {
+	sg.close()
+
+	for _, path := range sg.paths {
+		_ = os.Remove(path)
+	}
+}
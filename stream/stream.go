@@ -1,59 +1,253 @@
 package stream
 
 import (
+	"context"
+	"github.com/beoboo/job-scheduler/library/log"
 	"github.com/beoboo/job-scheduler/library/logsync"
 	"io"
+	"sort"
 	"sync"
+	"time"
 )
 
+// Stream stores Lines, that can be read concurrently (from the beginning) while new ones are written.
+//
+// Lines are positioned from 0, in the order they're written. When the memory used by the lines exceeds the
+// configured limit, the oldest ones are either dropped or spilled to disk:
+// - [0, dropped) are no more available
+// - [dropped, offset) are spilled to disk
+// - [offset, offset + len(lines) - head) are in memory
 type Stream struct {
-	lines  Lines
-	closed bool
-	m      logsync.Mutex
-	cond   *sync.Cond
+	lines    Lines
+	head     int
+	offset   int
+	dropped  int
+	size     int
+	maxBytes int
+	spillDir string
+	spill    *segment
+	log      *LogFile
+	closed   bool
+	m        logsync.Mutex
+	cond     *sync.Cond
+}
+
+// Option configures a Stream.
+type Option func(s *Stream)
+
+// WithMaxBytes caps the memory used by the text of the lines (0 means no limit).
+// When the cap is exceeded, the oldest lines are dropped, unless WithSpillDir is used too.
+func WithMaxBytes(maxBytes int) Option {
+	return func(s *Stream) {
+		s.maxBytes = maxBytes
+	}
+}
+
+// WithSpillDir moves the lines exceeding the memory cap to a file in dir, instead of dropping them.
+func WithSpillDir(dir string) Option {
+	return func(s *Stream) {
+		s.spillDir = dir
+	}
+}
+
+// WithLogFile persists all the lines written to the stream to a log file, that can be reopened with Open.
+// The log file is closed with the stream.
+func WithLogFile(lf *LogFile) Option {
+	return func(s *Stream) {
+		s.log = lf
+	}
+}
+
+// ReadOption configures where Stream.Read starts from, and if it follows the stream.
+// The options setting the first line are alternative, and the last one wins.
+type ReadOption func(o *readOptions)
+
+type readOptions struct {
+	start  func(s *Stream) int
+	follow bool
+	types  []StreamType
+}
+
+// matches checks if a line has to be returned to the reader
+func (o *readOptions) matches(l *Line) bool {
+	if len(o.types) == 0 {
+		return true
+	}
+
+	for _, t := range o.types {
+		if l.Type == t {
+			return true
+		}
+	}
+
+	return false
+}
+
+// FromPos starts reading from the line at pos (i.e. the Pos of the last line read plus one, to resume reading).
+func FromPos(pos int) ReadOption {
+	return func(o *readOptions) {
+		o.start = func(s *Stream) int {
+			if pos < 0 {
+				return 0
+			}
+			return pos
+		}
+	}
+}
+
+// Since starts reading from the first line written at or after t.
+func Since(t time.Time) ReadOption {
+	return func(o *readOptions) {
+		o.start = func(s *Stream) int {
+			// Lines are written in order, so their times are sorted
+			first := s.dropped
+			return first + sort.Search(s.total()-first, func(i int) bool {
+				return !s.timeAt(first + i).Before(t)
+			})
+		}
+	}
+}
+
+// Tail starts reading from the last n lines available.
+func Tail(n int) ReadOption {
+	return func(o *readOptions) {
+		o.start = func(s *Stream) int {
+			pos := s.total() - n
+			if pos < s.dropped {
+				pos = s.dropped
+			}
+			return pos
+		}
+	}
+}
+
+// NoFollow reads only the lines available when starting, instead of waiting for new ones until the stream is closed.
+func NoFollow() ReadOption {
+	return func(o *readOptions) {
+		o.follow = false
+	}
+}
+
+// OfType reads only the lines of the given types.
+func OfType(types ...StreamType) ReadOption {
+	return func(o *readOptions) {
+		o.types = types
+	}
 }
 
 // New creates a new Stream.
-func New() *Stream {
+func New(opts ...Option) *Stream {
 	s := &Stream{
 		lines: Lines{},
 		m:     logsync.NewMutex("Stream"),
 	}
 
+	for _, opt := range opts {
+		opt(s)
+	}
+
 	s.cond = sync.NewCond(&s.m)
 	return s
 }
 
+// Open creates a closed Stream reading the lines persisted in a log file (and its rotated files).
+// The lines are read from disk when needed, and never loaded in memory all together (the files are only kept open
+// while reading a line, so there's nothing to close).
+func Open(path string) (*Stream, error) {
+	sg, err := openSegment(logFiles(path))
+	if err != nil {
+		return nil, err
+	}
+
+	s := New()
+	s.spill = sg
+	s.offset = sg.len()
+	s.closed = true
+
+	return s, nil
+}
+
 // Read returns a channel of available Lines, if it's not been read, or blocks until the next one is written.
-func (s *Stream) Read() <-chan *Line {
-	next := make(chan *Line)
+// By default, it starts from the first line, and follows the stream until it's closed (see ReadOption).
+// If some lines have been dropped before being read, the next line reports how many have been skipped.
+//
+// The channel has to be drained until it's closed, or the goroutine feeding it is leaked: if the reader can stop
+// earlier, ReadContext has to be used instead.
+func (s *Stream) Read(opts ...ReadOption) <-chan *Line {
+	return s.ReadContext(context.Background(), opts...)
+}
+
+// ReadContext works like Read, but the channel is also closed (releasing its goroutine) when ctx is done.
+func (s *Stream) ReadContext(ctx context.Context, opts ...ReadOption) <-chan *Line {
+	o := readOptions{follow: true}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	s.m.RLock("Read")
 	pos := 0
+	if o.start != nil {
+		pos = o.start(s)
+	}
+	end := s.total()
+	s.m.RUnlock("Read")
+
+	next := make(chan *Line)
+	done := make(chan struct{})
 
 	go func() {
 		defer close(next)
+		defer close(done)
 
 		for {
 			s.m.Lock()
-			if s.hasData(pos) {
-				next <- s.readNext(pos)
-				pos += 1
-				s.m.Unlock()
-			} else {
+			for !s.hasData(pos) && !s.closed && o.follow && ctx.Err() == nil {
 				s.cond.Wait()
+			}
 
-				if s.closed {
-					s.m.Unlock()
-					break
-				}
-
+			if ctx.Err() != nil || !s.hasData(pos) || (!o.follow && pos >= end) {
+				// All the lines have been read from a closed stream (or the ones available when not following it),
+				// or the reader is gone
 				s.m.Unlock()
+				break
+			}
+
+			var line *Line
+			line, pos = s.readNext(pos)
+			s.m.Unlock()
+
+			if line == nil || !o.matches(line) {
+				continue
+			}
+
+			select {
+			case next <- line:
+			case <-ctx.Done():
+				return
 			}
 		}
 	}()
 
+	if ctx.Done() != nil {
+		go s.wakeUpOnDone(ctx, done)
+	}
+
 	return next
 }
 
+// wakeUpOnDone wakes up the readers waiting for new lines when ctx is done, so that they can exit.
+// It returns as soon as the reader is done.
+func (s *Stream) wakeUpOnDone(ctx context.Context, done <-chan struct{}) {
+	select {
+	case <-ctx.Done():
+		// The lock guarantees that the reader is either waiting, or will see that ctx is done before waiting
+		s.m.Lock()
+		s.cond.Broadcast()
+		s.m.Unlock()
+	case <-done:
+	}
+}
+
 // Write adds a new Line, or returns io.ErrClosedPipe if the stream is closed.
 func (s *Stream) Write(line Line) error {
 	s.m.WLock("Write")
@@ -63,7 +257,16 @@ func (s *Stream) Write(line Line) error {
 		return io.ErrClosedPipe
 	}
 
+	if s.log != nil {
+		if err := s.log.write(&line); err != nil {
+			log.Errorf("Cannot write to log file: %v\n", err)
+		}
+	}
+
 	s.lines = append(s.lines, line)
+	s.size += len(line.Text)
+
+	s.shrink()
 
 	s.cond.Broadcast()
 
@@ -88,13 +291,112 @@ func (s *Stream) Close() {
 	}
 
 	s.closed = true
+	if s.log != nil {
+		s.log.close()
+	}
+	// The spilled lines can still be read, but the file is not kept open anymore
+	if s.spill != nil {
+		s.spill.close()
+	}
 	s.cond.Broadcast()
 }
 
 func (s *Stream) hasData(pos int) bool {
-	return pos < len(s.lines)
+	return pos < s.total()
+}
+
+// total returns the number of lines written to the stream
+func (s *Stream) total() int {
+	return s.offset + len(s.lines) - s.head
+}
+
+// timeAt returns the time of the line at pos (that must be available)
+func (s *Stream) timeAt(pos int) time.Time {
+	if pos < s.offset {
+		l, err := s.spill.read(pos)
+		if err != nil {
+			return time.Time{}
+		}
+		return l.Time
+	}
+
+	return s.lines[s.head+pos-s.offset].Time
+}
+
+// readNext returns a copy of the line at pos (or the first one still available), and the position of the next one.
+// The line is nil if it cannot be read.
+func (s *Stream) readNext(pos int) (*Line, int) {
+	skipped := 0
+	if pos < s.dropped {
+		skipped = s.dropped - pos
+		pos = s.dropped
+	}
+
+	var line Line
+	if pos < s.offset {
+		l, err := s.spill.read(pos)
+		if err != nil {
+			log.Errorf("Cannot read spilled line %d: %v\n", pos, err)
+			return nil, pos + 1
+		}
+		line = *l
+	} else {
+		line = s.lines[s.head+pos-s.offset]
+	}
+
+	line.Skipped = skipped
+	line.Pos = pos
+
+	return &line, pos + 1
 }
 
-func (s *Stream) readNext(pos int) *Line {
-	return &s.lines[pos]
+// shrink moves the oldest lines out of memory, until the memory cap is respected (the last line is always kept)
+func (s *Stream) shrink() {
+	for s.maxBytes > 0 && s.size > s.maxBytes && len(s.lines)-s.head > 1 {
+		line := s.lines[s.head]
+
+		if s.spillDir != "" {
+			if err := s.spillLine(&line); err != nil {
+				log.Errorf("Cannot spill line, dropping it: %v\n", err)
+				s.stopSpilling()
+			}
+		}
+
+		s.lines[s.head] = Line{}
+		s.head += 1
+		s.offset += 1
+		s.size -= len(line.Text)
+
+		if s.spillDir == "" {
+			s.dropped = s.offset
+		}
+	}
+
+	// The lines are compacted once the evicted ones are the majority, so that the cost is amortized
+	if s.head > 0 && s.head >= len(s.lines)/2 {
+		s.lines = append(Lines{}, s.lines[s.head:]...)
+		s.head = 0
+	}
+}
+
+func (s *Stream) spillLine(line *Line) error {
+	if s.spill == nil {
+		spill, err := newSegment(s.spillDir)
+		if err != nil {
+			return err
+		}
+		s.spill = spill
+	}
+
+	return s.spill.append(line)
+}
+
+// stopSpilling drops all the spilled lines, after the spill file can't be written anymore
+func (s *Stream) stopSpilling() {
+	if s.spill != nil {
+		s.spill.remove()
+		s.spill = nil
+	}
+
+	s.spillDir = ""
 }
@@ -1,6 +1,10 @@
 package stream
 
 import (
+	"context"
+	"io"
+	"io/ioutil"
+	"runtime"
 	"sync"
 	"testing"
 	"time"
@@ -67,6 +71,21 @@ func TestStreamAllLines(t *testing.T) {
 	assertLine(t, <-l, "line2")
 }
 
+func TestStreamReadClosedStream(t *testing.T) {
+	s := New()
+	_ = s.Write(buildLine("line"))
+	s.Close()
+
+	res := ""
+	for l := range s.Read() {
+		res += string(l.Text)
+	}
+
+	if res != "line" {
+		t.Fatalf("Didn't read successfully, expected \"%s\", got \"%s\"", "line", res)
+	}
+}
+
 func TestStreamCannotWriteToClosedStream(t *testing.T) {
 	s := New()
 	s.Close()
@@ -133,6 +152,287 @@ func TestStreamConcurrentReads(t *testing.T) {
 	}
 }
 
+func TestStreamMaxBytesDropsOldestLines(t *testing.T) {
+	s := New(WithMaxBytes(8))
+	writeAll(s, "#001", "#002", "#003", "#004", "#005")
+	s.Close()
+
+	lines := readAll(s)
+
+	if len(lines) != 2 {
+		t.Fatalf("Expected %d lines, got %d", 2, len(lines))
+	}
+	assertLine(t, lines[0], "#004")
+	assertLine(t, lines[1], "#005")
+
+	if lines[0].Skipped != 3 || lines[1].Skipped != 0 {
+		t.Fatalf("Expected 3 skipped lines, got %d and %d", lines[0].Skipped, lines[1].Skipped)
+	}
+}
+
+func TestStreamMaxBytesKeepsLastLine(t *testing.T) {
+	s := New(WithMaxBytes(2))
+	writeAll(s, "#001", "#002")
+	s.Close()
+
+	lines := readAll(s)
+
+	if len(lines) != 1 {
+		t.Fatalf("Expected %d lines, got %d", 1, len(lines))
+	}
+	assertLine(t, lines[0], "#002")
+}
+
+func TestStreamSpillToDisk(t *testing.T) {
+	dir := t.TempDir()
+	s := New(WithMaxBytes(8), WithSpillDir(dir))
+	expected := []string{"#001", "#002", "#003", "#004", "#005"}
+	writeAll(s, expected...)
+	s.Close()
+
+	if s.spill.files != nil {
+		t.Fatalf("Spill file should be closed")
+	}
+
+	lines := readAll(s)
+
+	if len(lines) != len(expected) {
+		t.Fatalf("Expected %d lines, got %d", len(expected), len(lines))
+	}
+	for i, e := range expected {
+		assertLine(t, lines[i], e)
+		if lines[i].Skipped != 0 {
+			t.Fatalf("No lines should be skipped")
+		}
+	}
+
+	if len(s.lines)-s.head != 2 {
+		t.Fatalf("Expected %d lines in memory, got %d", 2, len(s.lines)-s.head)
+	}
+}
+
+func TestStreamSpillPreservesLines(t *testing.T) {
+	s := New(WithMaxBytes(1), WithSpillDir(t.TempDir()))
+	expected := Line{Time: time.Now(), Type: Error, Text: []byte("line")}
+	_ = s.Write(expected)
+	_ = s.Write(buildLine("last"))
+	s.Close()
+
+	l := <-s.Read()
+
+	if !l.Time.Equal(expected.Time) || l.Type != expected.Type || string(l.Text) != string(expected.Text) {
+		t.Fatalf("Expected %s, got %s", expected.String(), l)
+	}
+}
+
+func TestStreamReadFromPos(t *testing.T) {
+	s := New()
+	writeAll(s, "#1", "#2", "#3")
+	s.Close()
+
+	lines := readAll(s)
+	resumed := readAll(s, FromPos(lines[0].Pos+1))
+
+	assertLines(t, resumed, "#2", "#3")
+	if resumed[0].Pos != 1 {
+		t.Fatalf("Expected position %d, got %d", 1, resumed[0].Pos)
+	}
+}
+
+func TestStreamReadFromDroppedPos(t *testing.T) {
+	s := New(WithMaxBytes(4))
+	writeAll(s, "#1", "#2", "#3", "#4")
+	s.Close()
+
+	lines := readAll(s, FromPos(1))
+
+	assertLines(t, lines, "#3", "#4")
+	if lines[0].Skipped != 1 {
+		t.Fatalf("Expected %d skipped lines, got %d", 1, lines[0].Skipped)
+	}
+}
+
+func TestStreamReadSince(t *testing.T) {
+	s := New()
+	now := time.Now()
+	for i, text := range []string{"#1", "#2", "#3"} {
+		_ = s.Write(Line{Time: now.Add(time.Duration(i) * time.Second), Text: []byte(text)})
+	}
+	s.Close()
+
+	assertLines(t, readAll(s, Since(now.Add(time.Second))), "#2", "#3")
+	assertLines(t, readAll(s, Since(now.Add(500*time.Millisecond))), "#2", "#3")
+	assertLines(t, readAll(s, Since(now.Add(time.Minute))))
+}
+
+func TestStreamReadSinceSpilled(t *testing.T) {
+	s := New(WithMaxBytes(2), WithSpillDir(t.TempDir()))
+	now := time.Now()
+	for i, text := range []string{"#1", "#2", "#3"} {
+		_ = s.Write(Line{Time: now.Add(time.Duration(i) * time.Second), Text: []byte(text)})
+	}
+	s.Close()
+
+	assertLines(t, readAll(s, Since(now.Add(time.Second))), "#2", "#3")
+}
+
+func TestStreamReadTail(t *testing.T) {
+	s := New()
+	writeAll(s, "#1", "#2", "#3")
+	s.Close()
+
+	assertLines(t, readAll(s, Tail(2)), "#2", "#3")
+	assertLines(t, readAll(s, Tail(5)), "#1", "#2", "#3")
+}
+
+func TestStreamReadNoFollow(t *testing.T) {
+	s := New()
+	defer s.Close()
+	writeAll(s, "#1", "#2")
+
+	// The stream is still open, but the read stops at the available lines
+	assertLines(t, readAll(s, NoFollow()), "#1", "#2")
+	assertLines(t, readAll(s, Tail(1), NoFollow()), "#2")
+}
+
+func TestStreamReadContextCancelled(t *testing.T) {
+	s := New()
+	defer s.Close()
+	writeAll(s, "#1", "#2")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	lines := s.ReadContext(ctx)
+
+	assertLine(t, <-lines, "#1")
+	cancel()
+
+	// The next line might have been already sent, but the channel gets closed anyway
+	for range lines {
+	}
+}
+
+func TestStreamAbandonedReadersDoNotLeak(t *testing.T) {
+	s := New()
+	defer s.Close()
+	writeAll(s, "#1", "#2")
+
+	before := runtime.NumGoroutine()
+
+	var cancels []context.CancelFunc
+	for i := 0; i < 100; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancels = append(cancels, cancel)
+
+		lines := s.ReadContext(ctx)
+		if i%2 == 0 {
+			// Half the readers stop after a line (blocking the goroutine on the next one),
+			// the others after reading everything (blocking it while waiting for new lines)
+			<-lines
+		} else {
+			<-lines
+			<-lines
+		}
+	}
+
+	for _, cancel := range cancels {
+		cancel()
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if after := runtime.NumGoroutine(); after > before {
+		t.Fatalf("Expected %d goroutines, got %d", before, after)
+	}
+}
+
+func TestStreamReadOfType(t *testing.T) {
+	s := New()
+	_ = s.Write(Line{Type: Output, Text: []byte("out1")})
+	_ = s.Write(Line{Type: Error, Text: []byte("err")})
+	_ = s.Write(Line{Type: Output, Text: []byte("out2")})
+	s.Close()
+
+	assertLines(t, readAll(s, OfType(Output)), "out1", "out2")
+	assertLines(t, readAll(s, OfType(Error)), "err")
+	assertLines(t, readAll(s, OfType(Output, Error)), "out1", "err", "out2")
+}
+
+func TestStreamReader(t *testing.T) {
+	s := New()
+	_ = s.Write(Line{Type: Output, Text: []byte("out1\n")})
+	_ = s.Write(Line{Type: Error, Text: []byte("err\n")})
+	_ = s.Write(Line{Type: Output, Text: []byte("out2\n")})
+	s.Close()
+
+	r := NewReader(s, OfType(Output))
+	defer r.Close()
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != "out1\nout2\n" {
+		t.Fatalf("Expected \"%s\", got \"%s\"", "out1\nout2\n", data)
+	}
+}
+
+func TestStreamReaderSmallBuffer(t *testing.T) {
+	s := New()
+	writeAll(s, "abc", "de")
+	s.Close()
+
+	r := NewReader(s)
+	defer r.Close()
+
+	buf := make([]byte, 2)
+	res := ""
+	for {
+		n, err := r.Read(buf)
+		res += string(buf[:n])
+		if err == io.EOF {
+			break
+		}
+	}
+
+	if res != "abcde" {
+		t.Fatalf("Expected \"%s\", got \"%s\"", "abcde", res)
+	}
+}
+
+func TestStreamReaderClose(t *testing.T) {
+	s := New()
+	defer s.Close()
+	writeAll(s, "line")
+
+	r := NewReader(s)
+	_ = r.Close()
+
+	// The stream is still open, but the reader is not following it anymore
+	data, _ := ioutil.ReadAll(r)
+	if len(data) > len("line") {
+		t.Fatalf("Unexpected data after closing: \"%s\"", data)
+	}
+}
+
+func writeAll(s *Stream, texts ...string) {
+	for _, text := range texts {
+		_ = s.Write(buildLine(text))
+	}
+}
+
+func readAll(s *Stream, opts ...ReadOption) []*Line {
+	var lines []*Line
+	for l := range s.Read(opts...) {
+		lines = append(lines, l)
+	}
+
+	return lines
+}
+
 func write(s *Stream, t string) {
 	go func() {
 		_ = s.Write(buildLine(t))
@@ -154,6 +454,16 @@ func assertRead(t *testing.T, s *Stream, expected string) {
 	}
 }
 
+func assertLines(t *testing.T, lines []*Line, expected ...string) {
+	if len(lines) != len(expected) {
+		t.Fatalf("Expected %d lines, got %d", len(expected), len(lines))
+	}
+
+	for i, e := range expected {
+		assertLine(t, lines[i], e)
+	}
+}
+
 func assertLine(t *testing.T, l *Line, expected string) {
 	if string(l.Text) != expected {
 		t.Fatalf("Didn't read successfully, expected \"%s\", got \"%s\"", expected, l.Text)
@@ -5,6 +5,10 @@ type StreamType int
 const (
 	Output StreamType = 1
 	Error  StreamType = 2
+	// Tty is the output of a job running in a pseudo-terminal, where stdout and stderr can't be told apart
+	Tty StreamType = 3
+	// Event is a line written by the scheduler, not by the job (i.e. to mark a new attempt)
+	Event StreamType = 4
 )
 
 func (c StreamType) String() string {
@@ -13,6 +17,10 @@ func (c StreamType) String() string {
 		return "output"
 	case Error:
 		return "error"
+	case Tty:
+		return "tty"
+	case Event:
+		return "event"
 	}
 
 	return "undefined"